@@ -0,0 +1,39 @@
+// Command reconcile removes the uploaded files that no user, article or upload
+// references anymore. Without -delete it only lists them. Run it from the root
+// of the repository, so that the config is found:
+//
+//	go run ./cmd/reconcile -delete
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/services"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+func main() {
+	remove := flag.Bool("delete", false, "delete the orphaned files instead of only listing them")
+	grace := flag.Duration("grace", 24*time.Hour, "skip files modified within this period, which may belong to uploads in progress")
+	flag.Parse()
+
+	c := config.NewConfig()
+	conn := database.NewDatabaseConnection(c)
+	file := services.NewFileService(c)
+	sr := services.NewStorageReconciler(conn, file)
+
+	report, err := sr.Reconcile(time.Now().Add(-*grace), *remove)
+
+	if err != nil {
+		log.Fatalf("error reconciling storage: %v", err)
+	}
+
+	for _, object := range report.Orphaned {
+		fmt.Printf("%s\t%d\t%s\n", object.Key, object.Size, object.LastModified.Format(time.RFC3339))
+	}
+
+	log.Infof("checked %d files, %d orphaned, %d removed", report.Checked, len(report.Orphaned), report.Removed)
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"strconv"
+)
+
+type AdminController interface {
+	GetUsers(c *gin.Context)
+	UpdateUserRole(c *gin.Context)
+}
+
+type adminController struct {
+	us services.UserService
+}
+
+func NewAdminController(us services.UserService) AdminController {
+	return &adminController{us}
+}
+
+func (ac *adminController) GetUsers(c *gin.Context) {
+	page := 0
+	pageQuery := c.Query("p")
+	if pageQuery != "" {
+		p, err := strconv.Atoi(pageQuery)
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid page query parameter")))
+			return
+		}
+		page = p
+	}
+
+	users, err := ac.us.List(c.Query("search"), LIMIT, page)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+	return
+}
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func (ac *adminController) UpdateUserRole(c *gin.Context) {
+	var req roleRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"field": "role",
+			"error": "must be one of user, moderator or admin",
+		})
+		return
+	}
+
+	authUser := c.MustGet("user").(*models.User)
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	if uint(id) == authUser.ID {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("cannot change your own role")))
+		return
+	}
+
+	user, err := ac.us.GetById(uint(id))
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if err := ac.us.SetRole(user.ID, req.Role); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	user.Role = req.Role
+
+	c.JSON(http.StatusOK, user)
+	return
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ArticleController interface {
@@ -29,6 +30,8 @@ type ArticleController interface {
 	UnfavoriteArticle(c *gin.Context)
 	BookmarkArticle(c *gin.Context)
 	UnbookmarkArticle(c *gin.Context)
+	PublishArticle(c *gin.Context)
+	UnpublishArticle(c *gin.Context)
 }
 
 const LIMIT = 20
@@ -71,7 +74,7 @@ func (ac *articleController) GetArticles(c *gin.Context) {
 			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid limit query parameter")))
 			return
 		}
-		if l < LIMIT {
+		if l > 0 && l < LIMIT {
 			limit = l
 		}
 	}
@@ -88,16 +91,15 @@ func (ac *articleController) GetArticles(c *gin.Context) {
 		order = orderQuery
 	}
 
-	limitPlusOne := limit + 1
-
 	search := c.Query("search")
 	tag := c.Query("tag")
 	author := c.Query("author")
 	cursor := c.Query("cursor")
 	favorited := c.Query("favorited")
+	current := utils.GetUser(c)
 
 	query := services.ListQuery{
-		Limit:     limitPlusOne,
+		Limit:     limit,
 		Page:      page,
 		Cursor:    cursor,
 		Tag:       tag,
@@ -105,31 +107,27 @@ func (ac *articleController) GetArticles(c *gin.Context) {
 		Favorited: favorited,
 		Order:     order,
 		Search:    search,
+		ViewerId:  viewerId(current),
 	}
 
-	articles, err := ac.as.List(query)
+	articles, next, err := ac.as.List(query)
 
 	if err != nil {
-		c.JSON(utils.ErrorFromDatabase(err))
+		c.JSON(utils.ErrorFromService(err))
 		return
 	}
 
-	current := utils.GetUser(c)
-
 	response := make([]models.ArticleResponse, 0)
 
-	if len(*articles) > 0 {
-		for i, a := range *articles {
-			if i != limit {
-				article := ArticleSerializer(&a, current)
-				response = append(response, article)
-			}
-		}
+	for _, a := range *articles {
+		article := ArticleSerializer(&a, current)
+		response = append(response, article)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"articles": response,
-		"hasMore":  len(*articles) == limitPlusOne,
+		"articles":   response,
+		"hasMore":    next != "",
+		"nextCursor": next,
 	})
 	return
 }
@@ -140,6 +138,8 @@ type articleInput struct {
 	Body        string                `form:"body" binding:"required"`
 	Image       *multipart.FileHeader `form:"image" binding:"omitempty"`
 	TagList     []string              `form:"tagList" binding:"required"`
+	Status      string                `form:"status" binding:"omitempty,oneof=draft published unlisted"`
+	PublishAt   string                `form:"publishAt" binding:"omitempty"`
 }
 
 func (ac *articleController) CreateArticle(c *gin.Context) {
@@ -178,11 +178,27 @@ func (ac *articleController) CreateArticle(c *gin.Context) {
 		Body:        req.Body,
 		Author:      *authUser,
 		Image:       fmt.Sprintf("https://picsum.photos/seed/%s/1080", seed),
+		Status:      models.StatusPublished,
+	}
+
+	if err := setPublication(&a, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"field": "publishAt",
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if a.Status != models.StatusDraft || a.PublishAt != nil {
+		if !authUser.EmailVerified {
+			c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("verify your email before publishing")))
+			return
+		}
 	}
 
 	if req.Image != nil {
 
-		mimeType := req.Image.Header.Get("Content-Type")
+		mimeType := imageType(req.Image)
 
 		// Validate image mime-type is allowable
 		if valid := isAllowedImageType(mimeType); !valid {
@@ -195,16 +211,16 @@ func (ac *articleController) CreateArticle(c *gin.Context) {
 		}
 
 		directory := fmt.Sprintf("gin/users/%d", authUser.ID)
-		url, err := ac.fs.UploadImage(req.Image, directory)
+		image, err := ac.fs.UploadImage(req.Image, directory)
 
 		if err != nil {
-			c.JSON(500, gin.H{
-				"error": err,
-			})
+			c.JSON(utils.ErrorFromService(err))
 			return
 		}
 
-		a.Image = url
+		a.Image = image.URL
+		a.ImageKey = image.Key
+		a.ImageVariants = image.Variants
 	}
 
 	err := ac.as.SetArticleTags(req.TagList, &a)
@@ -249,34 +265,29 @@ func (ac *articleController) GetFeed(c *gin.Context) {
 			return
 		}
 
-		if l < LIMIT {
+		if l > 0 && l < LIMIT {
 			limit = l
 		}
 	}
 
-	limitPlusOne := limit + 1
-
-	articles, err := ac.as.Feed(current.ID, limitPlusOne, cursor, page)
+	articles, next, err := ac.as.Feed(current.ID, limit, cursor, page)
 
 	if err != nil {
-		c.JSON(utils.ErrorFromDatabase(err))
+		c.JSON(utils.ErrorFromService(err))
 		return
 	}
 
 	response := make([]models.ArticleResponse, 0)
 
-	if len(*articles) > 0 {
-		for i, a := range *articles {
-			if i != limit {
-				article := ArticleSerializer(&a, current)
-				response = append(response, article)
-			}
-		}
+	for _, a := range *articles {
+		article := ArticleSerializer(&a, current)
+		response = append(response, article)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"articles": response,
-		"hasMore":  len(*articles) == limitPlusOne,
+		"articles":   response,
+		"hasMore":    next != "",
+		"nextCursor": next,
 	})
 	return
 }
@@ -305,34 +316,29 @@ func (ac *articleController) GetBookmarked(c *gin.Context) {
 			return
 		}
 
-		if l < LIMIT {
+		if l > 0 && l < LIMIT {
 			limit = l
 		}
 	}
 
-	limitPlusOne := limit + 1
-
-	articles, err := ac.as.Bookmarked(current.ID, limitPlusOne, cursor, page)
+	articles, next, err := ac.as.Bookmarked(current.ID, limit, cursor, page)
 
 	if err != nil {
-		c.JSON(utils.ErrorFromDatabase(err))
+		c.JSON(utils.ErrorFromService(err))
 		return
 	}
 
 	response := make([]models.ArticleResponse, 0)
 
-	if len(*articles) > 0 {
-		for i, a := range *articles {
-			if i != limit {
-				article := ArticleSerializer(&a, current)
-				response = append(response, article)
-			}
-		}
+	for _, a := range *articles {
+		article := ArticleSerializer(&a, current)
+		response = append(response, article)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"articles": response,
-		"hasMore":  len(*articles) == limitPlusOne,
+		"articles":   response,
+		"hasMore":    next != "",
+		"nextCursor": next,
 	})
 	return
 }
@@ -368,7 +374,9 @@ func (ac *articleController) GetBySlug(c *gin.Context) {
 		return
 	}
 
-	article, err := ac.as.GetArticleBySlug(slg)
+	current := utils.GetUser(c)
+
+	article, err := ac.as.GetArticleBySlug(slg, viewerId(current))
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -382,8 +390,6 @@ func (ac *articleController) GetBySlug(c *gin.Context) {
 		return
 	}
 
-	current := utils.GetUser(c)
-
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
 }
@@ -416,7 +422,7 @@ func (ac *articleController) UpdateArticle(c *gin.Context) {
 	authUser := c.MustGet("user").(*models.User)
 
 	slg := c.Param("slug")
-	article, err := ac.as.GetArticleBySlug(slg)
+	article, err := ac.as.GetArticleBySlug(slg, authUser.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -442,9 +448,25 @@ func (ac *articleController) UpdateArticle(c *gin.Context) {
 	article.Body = req.Body
 	article.Author = *authUser
 
+	if err := setPublication(article, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"field": "publishAt",
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if article.Status != models.StatusDraft || article.PublishAt != nil {
+		if !authUser.EmailVerified {
+			c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("verify your email before publishing")))
+			return
+		}
+	}
+
+	var replaced *models.Article
 	if req.Image != nil {
 
-		mimeType := req.Image.Header.Get("Content-Type")
+		mimeType := imageType(req.Image)
 
 		// Validate image mime-type is allowable
 		if valid := isAllowedImageType(mimeType); !valid {
@@ -457,33 +479,38 @@ func (ac *articleController) UpdateArticle(c *gin.Context) {
 		}
 
 		directory := fmt.Sprintf("gin/users/%d", authUser.ID)
-		url, err := ac.fs.UploadImage(req.Image, directory)
+		image, err := ac.fs.UploadImage(req.Image, directory)
 
 		if err != nil {
-			c.JSON(500, gin.H{
-				"error": err,
-			})
+			c.JSON(utils.ErrorFromService(err))
 			return
 		}
 
-		_ = ac.fs.DeleteImage(article.Image)
-		article.Image = url
+		// The old images are only deleted once the article no longer points to them
+		replaced = &models.Article{ImageKey: article.ImageKey, ImageVariants: article.ImageVariants}
+		article.Image = image.URL
+		article.ImageKey = image.Key
+		article.ImageVariants = image.Variants
 	}
 
 	err = ac.as.SetArticleTags(req.TagList, article)
 
-	if err != nil {
-		c.JSON(utils.ErrorFromDatabase(err))
-		return
+	if err == nil {
+		err = ac.as.UpdateArticle(*article, authUser.ID)
 	}
 
-	err = ac.as.UpdateArticle(*article)
-
 	if err != nil {
+		if replaced != nil {
+			deleteArticleImages(ac.fs, article)
+		}
 		c.JSON(utils.ErrorFromDatabase(err))
 		return
 	}
 
+	if replaced != nil {
+		deleteArticleImages(ac.fs, replaced)
+	}
+
 	c.JSON(http.StatusCreated, ArticleSerializer(article, authUser))
 	return
 }
@@ -498,7 +525,9 @@ func (ac *articleController) DeleteArticle(c *gin.Context) {
 		return
 	}
 
-	article, err := ac.as.GetArticleBySlug(slg)
+	current := c.MustGet("user").(*models.User)
+
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -512,8 +541,6 @@ func (ac *articleController) DeleteArticle(c *gin.Context) {
 		return
 	}
 
-	current := c.MustGet("user").(*models.User)
-
 	if current.ID != article.AuthorId {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"message": "only the owner of the article is allowed to delete",
@@ -526,7 +553,7 @@ func (ac *articleController) DeleteArticle(c *gin.Context) {
 		return
 	}
 
-	_ = ac.fs.DeleteImage(article.Image)
+	deleteArticleImages(ac.fs, article)
 
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
@@ -534,8 +561,10 @@ func (ac *articleController) DeleteArticle(c *gin.Context) {
 
 func (ac *articleController) FavoriteArticle(c *gin.Context) {
 
+	current := c.MustGet("user").(*models.User)
+
 	slg := c.Param("slug")
-	article, err := ac.as.GetArticleBySlug(slg)
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -549,8 +578,6 @@ func (ac *articleController) FavoriteArticle(c *gin.Context) {
 		return
 	}
 
-	current := c.MustGet("user").(*models.User)
-
 	if !isFavorited(article, current) {
 		err := ac.as.Favorite(article, current)
 		if err != nil {
@@ -561,7 +588,7 @@ func (ac *articleController) FavoriteArticle(c *gin.Context) {
 		}
 	}
 
-	article, err = ac.as.GetArticleBySlug(slg)
+	article, err = ac.as.GetArticleBySlug(slg, current.ID)
 
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
@@ -569,8 +596,10 @@ func (ac *articleController) FavoriteArticle(c *gin.Context) {
 
 func (ac *articleController) UnfavoriteArticle(c *gin.Context) {
 
+	current := c.MustGet("user").(*models.User)
+
 	slg := c.Param("slug")
-	article, err := ac.as.GetArticleBySlug(slg)
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -584,8 +613,6 @@ func (ac *articleController) UnfavoriteArticle(c *gin.Context) {
 		return
 	}
 
-	current := c.MustGet("user").(*models.User)
-
 	if isFavorited(article, current) {
 		err := ac.as.Unfavorite(article, current)
 		if err != nil {
@@ -596,7 +623,7 @@ func (ac *articleController) UnfavoriteArticle(c *gin.Context) {
 		}
 	}
 
-	article, err = ac.as.GetArticleBySlug(slg)
+	article, err = ac.as.GetArticleBySlug(slg, current.ID)
 
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
@@ -604,8 +631,10 @@ func (ac *articleController) UnfavoriteArticle(c *gin.Context) {
 
 func (ac *articleController) BookmarkArticle(c *gin.Context) {
 
+	current := c.MustGet("user").(*models.User)
+
 	slg := c.Param("slug")
-	article, err := ac.as.GetArticleBySlug(slg)
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -619,8 +648,6 @@ func (ac *articleController) BookmarkArticle(c *gin.Context) {
 		return
 	}
 
-	current := c.MustGet("user").(*models.User)
-
 	if !isBookmarked(article, current) {
 		err := ac.as.Bookmark(article, current)
 		if err != nil {
@@ -631,7 +658,7 @@ func (ac *articleController) BookmarkArticle(c *gin.Context) {
 		}
 	}
 
-	article, err = ac.as.GetArticleBySlug(slg)
+	article, err = ac.as.GetArticleBySlug(slg, current.ID)
 
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
@@ -639,8 +666,10 @@ func (ac *articleController) BookmarkArticle(c *gin.Context) {
 
 func (ac *articleController) UnbookmarkArticle(c *gin.Context) {
 
+	current := c.MustGet("user").(*models.User)
+
 	slg := c.Param("slug")
-	article, err := ac.as.GetArticleBySlug(slg)
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -654,8 +683,6 @@ func (ac *articleController) UnbookmarkArticle(c *gin.Context) {
 		return
 	}
 
-	current := c.MustGet("user").(*models.User)
-
 	if isBookmarked(article, current) {
 		err := ac.as.Unbookmark(article, current)
 		if err != nil {
@@ -666,7 +693,75 @@ func (ac *articleController) UnbookmarkArticle(c *gin.Context) {
 		}
 	}
 
-	article, err = ac.as.GetArticleBySlug(slg)
+	article, err = ac.as.GetArticleBySlug(slg, current.ID)
+
+	c.JSON(http.StatusOK, ArticleSerializer(article, current))
+	return
+}
+
+func (ac *articleController) PublishArticle(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	slg := c.Param("slug")
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return
+	}
+
+	if current.ID != article.AuthorId {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "only the owner of the article is allowed to publish",
+		})
+		return
+	}
+
+	if err := ac.as.Publish(article); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, ArticleSerializer(article, current))
+	return
+}
+
+func (ac *articleController) UnpublishArticle(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	slg := c.Param("slug")
+	article, err := ac.as.GetArticleBySlug(slg, current.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return
+	}
+
+	if current.ID != article.AuthorId && !models.HasPermission(current.Role, models.PermUnpublishAnyArticle) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "only the owner of the article is allowed to unpublish",
+		})
+		return
+	}
+
+	if err := ac.as.Unpublish(article); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
 
 	c.JSON(http.StatusOK, ArticleSerializer(article, current))
 	return
@@ -688,11 +783,39 @@ func ArticleSerializer(article *models.Article, current *models.User) models.Art
 		Description:    article.Description,
 		Body:           article.Body,
 		Image:          article.Image,
+		ImageVariants:  ImageVariantsSerializer(article.ImageVariants),
+		Status:         article.Status,
+		PublishAt:      article.PublishAt,
+		PublishedAt:    article.PublishedAt,
 		TagList:        tagList,
 		Favorited:      isFavorited(article, current),
 		Bookmarked:     isBookmarked(article, current),
 		FavoritesCount: len(article.Favorites),
 		Author:         ProfileSerializer(&article.Author, current),
+		Highlight:      article.Headline,
+	}
+}
+
+// ImageVariantsSerializer returns the variants without their storage keys
+func ImageVariantsSerializer(variants models.ImageVariants) []models.ImageVariantResponse {
+	response := make([]models.ImageVariantResponse, 0, len(variants))
+	for _, v := range variants {
+		response = append(response, models.ImageVariantResponse{
+			URL:    v.URL,
+			Type:   v.Type,
+			Width:  v.Width,
+			Height: v.Height,
+		})
+	}
+	return response
+}
+
+// deleteArticleImages removes the image of the article in all its variants.
+// Placeholder images have no key and are left alone.
+func deleteArticleImages(fs services.FileService, article *models.Article) {
+	_ = fs.DeleteImage(article.ImageKey)
+	for _, v := range article.ImageVariants {
+		_ = fs.DeleteImage(v.Key)
 	}
 }
 
@@ -721,3 +844,43 @@ func isBookmarked(article *models.Article, current *models.User) bool {
 	}
 	return false
 }
+
+// setPublication applies the requested status to the article. A publishAt time
+// in the future keeps the article as a draft until the scheduler publishes it.
+func setPublication(a *models.Article, req *articleInput) error {
+	if req.Status != "" {
+		a.Status = req.Status
+		a.PublishAt = nil
+	}
+
+	if req.PublishAt != "" {
+		publishAt, err := time.Parse(time.RFC3339, req.PublishAt)
+		if err != nil {
+			return errors.New("must be a RFC 3339 timestamp")
+		}
+
+		if !publishAt.After(time.Now()) {
+			return errors.New("must be in the future")
+		}
+
+		a.Status = models.StatusDraft
+		a.PublishAt = &publishAt
+	}
+
+	if a.Status == models.StatusDraft {
+		a.PublishedAt = nil
+	} else if a.PublishedAt == nil {
+		now := time.Now()
+		a.PublishedAt = &now
+	}
+
+	return nil
+}
+
+// viewerId returns the id of the current user or 0 for anonymous requests
+func viewerId(current *models.User) uint {
+	if current == nil {
+		return 0
+	}
+	return current.ID
+}
@@ -6,38 +6,53 @@ import (
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	"github.com/sentrionic/OlympusGin/services"
 	"github.com/sentrionic/OlympusGin/utils"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 type AuthController interface {
 	Register(c *gin.Context)
 	Login(c *gin.Context)
+	LoginTwoFactor(c *gin.Context)
 	Logout(ctx *gin.Context)
 	ForgotPassword(ctx *gin.Context)
 	ResetPassword(ctx *gin.Context)
+	VerifyEmail(ctx *gin.Context)
+	ResendVerification(ctx *gin.Context)
 }
 
 type authController struct {
-	as    services.AuthService
-	redis services.RedisService
-	mail  services.MailService
+	as     services.AuthService
+	tfs    services.TwoFactorService
+	redis  services.RedisService
+	mail   services.MailService
+	policy services.PasswordPolicy
 }
 
-func NewAuthController(as services.AuthService, rs services.RedisService, ms services.MailService) AuthController {
+func NewAuthController(
+	as services.AuthService,
+	tfs services.TwoFactorService,
+	rs services.RedisService,
+	ms services.MailService,
+	pp services.PasswordPolicy,
+) AuthController {
 	return &authController{
-		as:    as,
-		redis: rs,
-		mail:  ms,
+		as:     as,
+		tfs:    tfs,
+		redis:  rs,
+		mail:   ms,
+		policy: pp,
 	}
 }
 
 type registerRequest struct {
 	Username string `json:"username" binding:"required,gte=3,lte=30"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,gte=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 func (ac *authController) Register(c *gin.Context) {
@@ -47,6 +62,10 @@ func (ac *authController) Register(c *gin.Context) {
 		return
 	}
 
+	if valid := validatePassword(c, ac.policy, "Password", req.Password, req.Username, req.Email); !valid {
+		return
+	}
+
 	u := models.User{
 		Username:     req.Username,
 		Email:        strings.ToLower(req.Email),
@@ -86,7 +105,9 @@ func (ac *authController) Register(c *gin.Context) {
 		return
 	}
 
-	setUserSession(c, user.ID)
+	sendVerificationEmail(c, ac.redis, ac.mail, user.ID, user.Email)
+
+	setUserSession(c, ac.as, user.ID)
 	c.JSON(http.StatusCreated, user)
 	return
 }
@@ -102,13 +123,65 @@ func (ac *authController) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := ac.as.Login(strings.ToLower(req.Email), req.Password)
+	user, err := ac.as.Login(c.Request.Context(), strings.ToLower(req.Email), req.Password, c.ClientIP())
 	if err != nil {
-		c.JSON(utils.ErrorFromDatabase(err))
+		var e *apperrors.Error
+		if errors.As(err, &e) && e.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+		}
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := ac.tfs.CreateChallenge(c.Request.Context(), user.ID)
+
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusInternalServerError, err))
+			return
+		}
+
+		c.JSON(http.StatusOK, twoFactorChallenge{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+		})
+		return
+	}
+
+	setUserSession(c, ac.as, user.ID)
+	c.JSON(http.StatusCreated, user)
+	return
+}
+
+type twoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	Challenge         string `json:"challenge"`
+}
+
+type loginTwoFactorRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+// LoginTwoFactor finishes the login of a user with two-factor authentication
+// using the challenge returned by Login and a TOTP or recovery code
+func (ac *authController) LoginTwoFactor(c *gin.Context) {
+	var req loginTwoFactorRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	user, err := ac.as.LoginTwoFactor(c.Request.Context(), req.Challenge, req.Code, c.ClientIP())
+	if err != nil {
+		var e *apperrors.Error
+		if errors.As(err, &e) && e.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+		}
+		c.JSON(utils.ErrorFromService(err))
 		return
 	}
 
-	setUserSession(c, user.ID)
+	setUserSession(c, ac.as, user.ID)
 	c.JSON(http.StatusCreated, user)
 	return
 }
@@ -117,6 +190,12 @@ func (ac *authController) Logout(c *gin.Context) {
 	c.Set("user", nil)
 
 	session := sessions.Default(c)
+
+	id, _ := session.Get("userId").(uint)
+	if sessionId, ok := session.Get("sessionId").(string); ok {
+		_ = ac.as.RevokeSession(c.Request.Context(), id, sessionId)
+	}
+
 	session.Set("userId", "")
 	session.Clear()
 	session.Options(sessions.Options{Path: "/", MaxAge: -1})
@@ -189,11 +268,17 @@ func (ac *authController) ResetPassword(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "Passwords do not match",
 		})
+		return
 	}
 
 	ctx := c.Request.Context()
 	id, err := ac.redis.GetIdFromToken(ctx, req.Token)
 
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid or expired token")))
+		return
+	}
+
 	user, err := ac.as.GetById(id)
 
 	if err != nil {
@@ -206,6 +291,17 @@ func (ac *authController) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// The token is only used up once the new password has been accepted
+	if valid := validatePassword(c, ac.policy, "Password", req.Password, user.Username, user.Email); !valid {
+		return
+	}
+
+	// Only one request can use up the token, concurrent ones are rejected
+	if consumed, err := ac.redis.ConsumeResetToken(ctx, req.Token); err != nil || consumed != user.ID {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid or expired token")))
+		return
+	}
+
 	err = ac.as.ChangePassword(*user, req.Password)
 
 	if err != nil {
@@ -215,16 +311,106 @@ func (ac *authController) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	setUserSession(c, user.ID)
+	if err := ac.as.RevokeSessions(ctx, user.ID, ""); err != nil {
+		fmt.Printf("error revoking sessions: %v", err)
+	}
+
+	// The reset link only proves access to the email, so users
+	// with two-factor authentication still have to log in
+	if !user.TOTPEnabled {
+		setUserSession(c, ac.as, user.ID)
+	}
 
 	c.JSON(http.StatusOK, user)
 	return
 }
 
-func setUserSession(c *gin.Context, id uint) {
+func setUserSession(c *gin.Context, as services.AuthService, id uint) {
 	session := sessions.Default(c)
+
+	sessionId, err := as.CreateSession(c.Request.Context(), id, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	session.Set("userId", id)
+	session.Set("sessionId", sessionId)
 	if err := session.Save(); err != nil {
 		fmt.Println(err)
 	}
 }
+
+type verifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyEmail confirms the email the token was sent to. For a pending
+// email change this is the moment the new address replaces the old one.
+func (ac *authController) VerifyEmail(c *gin.Context) {
+	var req verifyRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	id, email, err := ac.redis.GetVerifyToken(c.Request.Context(), req.Token)
+
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid or expired token")))
+		return
+	}
+
+	exists, err := ac.as.GetByEmail(email)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if exists.ID != 0 && exists.ID != id {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("a user with that email already exists")))
+		return
+	}
+
+	if err := ac.as.VerifyEmail(id, email); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	user, err := ac.as.GetById(id)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+	return
+}
+
+func (ac *authController) ResendVerification(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	if authUser.EmailVerified {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("email is already verified")))
+		return
+	}
+
+	sendVerificationEmail(c, ac.redis, ac.mail, authUser.ID, authUser.Email)
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func sendVerificationEmail(c *gin.Context, rs services.RedisService, ms services.MailService, id uint, email string) {
+	token, err := rs.SetVerifyToken(c.Request.Context(), id, email)
+
+	if err != nil {
+		fmt.Printf("error creating verification token: %v", err)
+		return
+	}
+
+	ms.SendVerificationEmail(services.VerifyInput{
+		Email: email,
+		Token: token,
+	})
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/sentrionic/OlympusGin/services"
 )
 
 // used to help extract validation errors
@@ -42,3 +43,24 @@ func bindData(c *gin.Context, req interface{}) bool {
 	}
 	return true
 }
+
+// validatePassword checks the password against the password policy and
+// responds with the reasons in the same shape as bindData if it is rejected
+func validatePassword(c *gin.Context, pp services.PasswordPolicy, field string, password string, username string, email string) bool {
+	reasons := pp.Validate(password, username, email)
+
+	if len(reasons) == 0 {
+		return true
+	}
+
+	var invalidArgs []invalidArgument
+	for _, reason := range reasons {
+		invalidArgs = append(invalidArgs, invalidArgument{field, reason})
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"message": "Invalid request parameters. See errors",
+		"errors":  invalidArgs,
+	})
+	return false
+}
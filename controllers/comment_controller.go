@@ -1,27 +1,35 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sentrionic/OlympusGin/models"
 	"github.com/sentrionic/OlympusGin/services"
 	"github.com/sentrionic/OlympusGin/utils"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type CommentController interface {
 	CreateComment(c *gin.Context)
 	GetArticleComments(c *gin.Context)
 	DeleteComment(c *gin.Context)
+	ReplyToComment(c *gin.Context)
+	GetThread(c *gin.Context)
+	UpdateComment(c *gin.Context)
+	GetCommentHistory(c *gin.Context)
 }
 
 type commentController struct {
 	cs services.CommentService
 	as services.ArticleService
+	es services.EventService
 }
 
-func NewCommentController(cs services.CommentService, as services.ArticleService) CommentController {
-	return &commentController{cs, as}
+func NewCommentController(cs services.CommentService, as services.ArticleService, es services.EventService) CommentController {
+	return &commentController{cs, as, es}
 }
 
 type commentRequest struct {
@@ -36,7 +44,7 @@ func (cc *commentController) CreateComment(c *gin.Context) {
 	authUser := c.MustGet("user").(*models.User)
 
 	slg := c.Param("slug")
-	article, err := cc.as.GetArticleBySlug(slg)
+	article, err := cc.as.GetArticleBySlug(slg, authUser.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -63,39 +71,191 @@ func (cc *commentController) CreateComment(c *gin.Context) {
 		return
 	}
 
+	cc.publishComment(c, comment)
+
 	c.JSON(http.StatusOK, CommentSerializer(comment, authUser))
 	return
 }
 
+// GetArticleComments returns a page of the article's comments. By default the
+// page is a flat list ordered by creation, with format=tree the page consists
+// of top level comments with their replies nested below them.
 func (cc *commentController) GetArticleComments(c *gin.Context) {
 	current := utils.GetUser(c)
 	slg := c.Param("slug")
 
-	comments, err := cc.cs.List(slg)
+	article, err := cc.as.GetArticleBySlug(slg, viewerId(current))
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
 		return
 	}
 
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return
+	}
+
+	// Without paging parameters every comment is returned as a plain list,
+	// which is what clients that predate the pagination expect
+	_, hasCursor := c.GetQuery("cursor")
+	_, hasLimit := c.GetQuery("limit")
+	_, hasFormat := c.GetQuery("format")
+
+	if !hasCursor && !hasLimit && !hasFormat {
+		comments, err := cc.cs.All(article.ID)
+
+		if err != nil {
+			c.JSON(utils.ErrorFromDatabase(err))
+			return
+		}
+
+		response := make([]models.CommentResponse, 0)
+
+		for _, c := range *comments {
+			response = append(response, CommentSerializer(&c, current))
+		}
+
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	limit := LIMIT
+	limitQuery := c.Query("limit")
+	if limitQuery != "" {
+		l, err := strconv.Atoi(limitQuery)
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid limit query parameter")))
+			return
+		}
+
+		if l > 0 && l < LIMIT {
+			limit = l
+		}
+	}
+
+	cursor := c.Query("cursor")
+
+	if c.Query("format") != "tree" {
+		comments, next, err := cc.cs.List(article.ID, limit, cursor)
+
+		if err != nil {
+			c.JSON(utils.ErrorFromService(err))
+			return
+		}
+
+		response := make([]models.CommentResponse, 0)
+
+		for _, c := range *comments {
+			comment := CommentSerializer(&c, current)
+			response = append(response, comment)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"comments":   response,
+			"hasMore":    next != "",
+			"nextCursor": next,
+		})
+		return
+	}
+
+	roots, next, err := cc.cs.ListRoots(article.ID, limit, cursor)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	var ids []uint
+	for _, root := range *roots {
+		ids = append(ids, root.ID)
+	}
+
 	response := make([]models.CommentResponse, 0)
 
-	if len(*comments) > 0 {
+	if len(ids) > 0 {
+		comments, err := cc.cs.Thread(ids)
+
+		if err != nil {
+			c.JSON(utils.ErrorFromDatabase(err))
+			return
+		}
+
+		response = CommentTreeSerializer(comments, current)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments":   response,
+		"hasMore":    next != "",
+		"nextCursor": next,
+	})
+	return
+}
+
+// GetThread returns the comment and all of its replies as a tree,
+// or as a flat list ordered by creation with format=flat
+func (cc *commentController) GetThread(c *gin.Context) {
+	current := utils.GetUser(c)
+
+	slg := c.Param("slug")
+	article, err := cc.as.GetArticleBySlug(slg, viewerId(current))
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return
+	}
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	comments, err := cc.cs.Thread([]uint{uint(id)})
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if len(*comments) == 0 || (*comments)[0].ArticleID != article.ID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no comment with that id",
+		})
+		return
+	}
+
+	if c.Query("format") == "flat" {
+		response := make([]models.CommentResponse, 0)
+
 		for _, c := range *comments {
 			comment := CommentSerializer(&c, current)
 			response = append(response, comment)
 		}
+
+		c.JSON(http.StatusOK, response)
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, CommentTreeSerializer(comments, current)[0])
 	return
 }
 
-func (cc *commentController) DeleteComment(c *gin.Context) {
+func (cc *commentController) ReplyToComment(c *gin.Context) {
+	var req commentRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
 	authUser := c.MustGet("user").(*models.User)
 
 	slg := c.Param("slug")
-	article, err := cc.as.GetArticleBySlug(slg)
+	article, err := cc.as.GetArticleBySlug(slg, authUser.ID)
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
@@ -112,20 +272,82 @@ func (cc *commentController) DeleteComment(c *gin.Context) {
 	param := c.Param("id")
 	id, _ := strconv.Atoi(param)
 
-	comment, err := cc.cs.Get(uint(id))
+	parent, err := cc.cs.Get(uint(id))
 
 	if err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
 		return
 	}
 
-	if comment.ID == 0 {
+	if parent.ArticleID != article.ID || parent.Deleted {
 		c.JSON(http.StatusNotFound, gin.H{
 			"message": "no comment with that id",
 		})
 		return
 	}
 
+	if parent.Depth >= models.MaxCommentDepth {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("maximum reply depth reached")))
+		return
+	}
+
+	nc := models.Comment{
+		Body:     req.Body,
+		Author:   *authUser,
+		Article:  *article,
+		ParentID: &parent.ID,
+		Depth:    parent.Depth + 1,
+	}
+
+	comment, err := cc.cs.Create(nc)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	cc.publishComment(c, comment)
+
+	c.JSON(http.StatusOK, CommentSerializer(comment, authUser))
+	return
+}
+
+func (cc *commentController) DeleteComment(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	comment, ok := cc.getArticleComment(c, authUser)
+	if !ok {
+		return
+	}
+
+	if comment.AuthorID != authUser.ID && !models.HasPermission(authUser.Role, models.PermDeleteAnyComment) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "not the owner of the comment",
+		})
+		return
+	}
+
+	if err := cc.cs.Delete(*comment); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, CommentSerializer(comment, authUser))
+	return
+}
+
+func (cc *commentController) UpdateComment(c *gin.Context) {
+	var req commentRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+	authUser := c.MustGet("user").(*models.User)
+
+	comment, ok := cc.getArticleComment(c, authUser)
+	if !ok {
+		return
+	}
+
 	if comment.AuthorID != authUser.ID {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"message": "not the owner of the comment",
@@ -133,23 +355,165 @@ func (cc *commentController) DeleteComment(c *gin.Context) {
 		return
 	}
 
-	err = cc.cs.Delete(*comment)
+	if comment.Deleted {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("deleted comments cannot be edited")))
+		return
+	}
 
-	if err != nil {
+	if time.Since(comment.CreatedAt) > models.CommentEditWindow {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("the time to edit this comment has passed")))
+		return
+	}
+
+	if err := cc.cs.Update(comment, req.Body, authUser.ID); err != nil {
 		c.JSON(utils.ErrorFromDatabase(err))
 		return
 	}
 
+	comment.Author = *authUser
+
 	c.JSON(http.StatusOK, CommentSerializer(comment, authUser))
 	return
 }
 
+func (cc *commentController) GetCommentHistory(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	comment, ok := cc.getArticleComment(c, authUser)
+	if !ok {
+		return
+	}
+
+	if comment.AuthorID != authUser.ID && !models.HasPermission(authUser.Role, models.PermViewCommentHistory) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "not the owner of the comment",
+		})
+		return
+	}
+
+	edits, err := cc.cs.History(comment.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.CommentEditResponse, 0)
+
+	for _, e := range *edits {
+		response = append(response, models.CommentEditResponse{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			Body:      e.Body,
+			Editor:    ProfileSerializer(&e.Editor, authUser),
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+// getArticleComment loads the comment from the id param and writes an error
+// response if it or the article from the slug param do not exist
+func (cc *commentController) getArticleComment(c *gin.Context, current *models.User) (*models.Comment, bool) {
+	slg := c.Param("slug")
+	article, err := cc.as.GetArticleBySlug(slg, current.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return nil, false
+	}
+
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return nil, false
+	}
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	comment, err := cc.cs.Get(uint(id))
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return nil, false
+	}
+
+	if comment.ArticleID != article.ID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no comment with that id",
+		})
+		return nil, false
+	}
+
+	return comment, true
+}
+
+// publishComment pushes the new comment to everyone viewing the article
+func (cc *commentController) publishComment(c *gin.Context, comment *models.Comment) {
+	topic := services.ArticleTopic(comment.ArticleID)
+	err := cc.es.Publish(c.Request.Context(), topic, models.EventComment, CommentSerializer(comment, nil))
+
+	if err != nil {
+		fmt.Printf("error publishing comment: %v", err)
+	}
+}
+
 func CommentSerializer(comment *models.Comment, current *models.User) models.CommentResponse {
-	return models.CommentResponse{
+	response := models.CommentResponse{
 		ID:        comment.ID,
 		CreatedAt: comment.CreatedAt,
 		UpdatedAt: comment.UpdatedAt,
 		Body:      comment.Body,
 		Author:    ProfileSerializer(&comment.Author, current),
+		ParentID:  comment.ParentID,
+		Depth:     comment.Depth,
+		Deleted:   comment.Deleted,
+		Edited:    comment.EditedAt != nil,
+		EditedAt:  comment.EditedAt,
 	}
+
+	if comment.Deleted {
+		response.Author = models.Profile{}
+	}
+
+	return response
+}
+
+// CommentTreeSerializer nests the comments below their parents and returns
+// the comments whose parent is not part of the list. The comments
+// must be ordered so that parents come before their replies.
+func CommentTreeSerializer(comments *[]models.Comment, current *models.User) []models.CommentResponse {
+	children := make(map[uint][]uint)
+	nodes := make(map[uint]models.CommentResponse)
+	var roots []uint
+
+	for _, c := range *comments {
+		nodes[c.ID] = CommentSerializer(&c, current)
+		if c.ParentID != nil {
+			if _, ok := nodes[*c.ParentID]; ok {
+				children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+				continue
+			}
+		}
+		roots = append(roots, c.ID)
+	}
+
+	var build func(id uint) models.CommentResponse
+	build = func(id uint) models.CommentResponse {
+		node := nodes[id]
+		for _, child := range children[id] {
+			node.Replies = append(node.Replies, build(child))
+		}
+		return node
+	}
+
+	response := make([]models.CommentResponse, 0)
+	for _, id := range roots {
+		response = append(response, build(id))
+	}
+
+	return response
 }
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxWatchedArticles limits how many articles a single stream can follow
+const maxWatchedArticles = 20
+
+// eventHeartbeat keeps proxies from closing idle streams
+const eventHeartbeat = 25 * time.Second
+
+type EventController interface {
+	Stream(c *gin.Context)
+}
+
+type eventController struct {
+	es services.EventService
+	as services.ArticleService
+	ps services.ProfileService
+}
+
+func NewEventController(es services.EventService, as services.ArticleService, ps services.ProfileService) EventController {
+	return &eventController{es, as, ps}
+}
+
+// Stream sends the events of the current user as Server-Sent Events: their
+// notifications, the articles published by the users they follow and the new
+// comments of the articles given as comma separated slugs in ?articles.
+// Clients resume with the Last-Event-ID header after reconnecting.
+func (ec *eventController) Stream(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	topics := []string{services.UserTopic(authUser.ID)}
+
+	following, err := ec.ps.FollowingIds(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	for _, id := range following {
+		topics = append(topics, services.FeedTopic(id))
+	}
+
+	if articles := c.Query("articles"); articles != "" {
+		slugs := strings.Split(articles, ",")
+
+		if len(slugs) > maxWatchedArticles {
+			c.JSON(utils.CreateApiError(http.StatusBadRequest, fmt.Errorf("cannot watch more than %d articles", maxWatchedArticles)))
+			return
+		}
+
+		for _, slug := range slugs {
+			article, err := ec.as.GetArticleBySlug(strings.TrimSpace(slug), authUser.ID)
+
+			if err != nil {
+				c.JSON(utils.ErrorFromDatabase(err))
+				return
+			}
+
+			if article.ID != 0 {
+				topics = append(topics, services.ArticleTopic(article.ID))
+			}
+		}
+	}
+
+	lastId := c.GetHeader("Last-Event-ID")
+	if lastId == "" {
+		lastId = c.Query("lastEventId")
+	}
+
+	if lastId != "" && !services.ValidEventID(lastId) {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid Last-Event-ID")))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Subscribe before replaying, so that no event falls between the two
+	sub := ec.es.Subscribe(topics)
+	defer ec.es.Unsubscribe(sub)
+
+	var replay []models.Event
+	complete := true
+
+	if lastId != "" {
+		replay, complete, err = ec.es.Replay(ctx, lastId, topics)
+
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusInternalServerError, err))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		writeEvent(c, models.Event{Type: models.EventReset, Data: json.RawMessage("{}")})
+	}
+
+	for _, e := range replay {
+		writeEvent(c, e)
+		lastId = e.ID
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+
+			// Already sent during the replay
+			if lastId != "" && services.CompareEventIDs(e.ID, lastId) <= 0 {
+				continue
+			}
+
+			writeEvent(c, e)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+func writeEvent(c *gin.Context, e models.Event) {
+	if e.ID != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", e.ID)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+}
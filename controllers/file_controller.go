@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+)
+
+type FileController interface {
+	ServeFile(c *gin.Context)
+}
+
+type fileController struct {
+	fs services.FileService
+}
+
+func NewFileController(fs services.FileService) FileController {
+	return &fileController{fs}
+}
+
+// ServeFile serves the files of the local and memory storage drivers.
+// Only the signed URLs handed out by the FileService are accepted.
+func (fc *fileController) ServeFile(c *gin.Context) {
+	key := "files" + c.Param("key")
+
+	if !fc.fs.VerifyURL(key, c.Query("expires"), c.Query("signature")) {
+		c.JSON(utils.CreateApiError(http.StatusNotFound, errors.New("file not found")))
+		return
+	}
+
+	body, err := fc.fs.GetImage(key)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+	defer body.Close()
+
+	// Seekable files support range and conditional requests
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, time.Time{}, seeker)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(path.Ext(key)), body, nil)
+}
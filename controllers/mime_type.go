@@ -1,5 +1,11 @@
 package controllers
 
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
 var validImageTypes = map[string]bool{
 	"image/jpeg": true,
 	"image/png":  true,
@@ -12,3 +18,19 @@ func isAllowedImageType(mimeType string) bool {
 
 	return exists
 }
+
+// imageType detects the type of the uploaded file from its first bytes,
+// since the Content-Type sent by the client cannot be trusted
+func imageType(header *multipart.FileHeader) string {
+	file, err := header.Open()
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// DetectContentType considers at most 512 bytes
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"strconv"
+)
+
+type NotificationController interface {
+	GetNotifications(c *gin.Context)
+	GetUnreadCount(c *gin.Context)
+	MarkRead(c *gin.Context)
+	MarkAllRead(c *gin.Context)
+}
+
+type notificationController struct {
+	ns services.NotificationService
+}
+
+func NewNotificationController(ns services.NotificationService) NotificationController {
+	return &notificationController{ns}
+}
+
+func (nc *notificationController) GetNotifications(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	page := 0
+	pageQuery := c.Query("p")
+	if pageQuery != "" {
+		p, err := strconv.Atoi(pageQuery)
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid page query parameter")))
+			return
+		}
+		page = p
+	}
+
+	notifications, err := nc.ns.List(authUser.ID, LIMIT, page)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.NotificationResponse, 0)
+	for _, n := range *notifications {
+		response = append(response, NotificationSerializer(&n, authUser))
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+func (nc *notificationController) GetUnreadCount(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	count, err := nc.ns.UnreadCount(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+	return
+}
+
+func (nc *notificationController) MarkRead(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid notification id")))
+		return
+	}
+
+	if err := nc.ns.MarkRead(authUser.ID, uint(id)); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func (nc *notificationController) MarkAllRead(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	if err := nc.ns.MarkAllRead(authUser.ID); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func NotificationSerializer(n *models.Notification, current *models.User) models.NotificationResponse {
+	response := models.NotificationResponse{
+		ID:         n.ID,
+		CreatedAt:  n.CreatedAt,
+		UpdatedAt:  n.UpdatedAt,
+		Type:       n.Type,
+		Message:    notificationMessage(n),
+		Actor:      ProfileSerializer(&n.Actor, current),
+		ActorCount: n.ActorCount,
+		Read:       n.ReadAt != nil,
+	}
+
+	if n.Article != nil {
+		response.Article = &models.NotificationArticle{
+			Slug:  n.Article.Slug,
+			Title: n.Article.Title,
+		}
+	}
+
+	return response
+}
+
+// notificationMessage describes the group, e.g. "alice and 4 others favorited your article"
+func notificationMessage(n *models.Notification) string {
+	actors := n.Actor.Username
+	switch others := n.ActorCount - 1; {
+	case others == 1:
+		actors += " and 1 other"
+	case others > 1:
+		actors += fmt.Sprintf(" and %d others", others)
+	}
+
+	title := ""
+	if n.Article != nil {
+		title = n.Article.Title
+	}
+
+	switch n.Type {
+	case models.NotificationFollow:
+		return fmt.Sprintf("%s started following you", actors)
+	case models.NotificationFavorite:
+		return fmt.Sprintf("%s favorited your article \"%s\"", actors, title)
+	case models.NotificationComment:
+		return fmt.Sprintf("%s commented on your article \"%s\"", actors, title)
+	default:
+		return actors
+	}
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"strconv"
+)
+
+type RevisionController interface {
+	GetRevisions(c *gin.Context)
+	GetRevision(c *gin.Context)
+	DiffRevisions(c *gin.Context)
+	RestoreRevision(c *gin.Context)
+}
+
+type revisionController struct {
+	rs services.RevisionService
+	as services.ArticleService
+}
+
+func NewRevisionController(rs services.RevisionService, as services.ArticleService) RevisionController {
+	return &revisionController{rs, as}
+}
+
+func (rc *revisionController) GetRevisions(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	article, ok := rc.getOwnedArticle(c, current)
+	if !ok {
+		return
+	}
+
+	revisions, err := rc.rs.List(article.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.RevisionResponse, 0)
+
+	for _, r := range *revisions {
+		response = append(response, RevisionSerializer(&r, current))
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+func (rc *revisionController) GetRevision(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	article, ok := rc.getOwnedArticle(c, current)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid version parameter")))
+		return
+	}
+
+	revision, err := rc.rs.Get(article.ID, version)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, RevisionSerializer(revision, current))
+	return
+}
+
+func (rc *revisionController) DiffRevisions(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	article, ok := rc.getOwnedArticle(c, current)
+	if !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid from query parameter")))
+		return
+	}
+
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid to query parameter")))
+		return
+	}
+
+	old, err := rc.rs.Get(article.ID, from)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	next, err := rc.rs.Get(article.ID, to)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":        RevisionSerializer(old, current),
+		"to":          RevisionSerializer(next, current),
+		"title":       utils.DiffLines(old.Title, next.Title),
+		"description": utils.DiffLines(old.Description, next.Description),
+		"body":        utils.DiffLines(old.Body, next.Body),
+		"tagList":     utils.DiffSlices(services.RevisionTags(old), services.RevisionTags(next)),
+	})
+	return
+}
+
+func (rc *revisionController) RestoreRevision(c *gin.Context) {
+	current := c.MustGet("user").(*models.User)
+
+	article, ok := rc.getOwnedArticle(c, current)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid version parameter")))
+		return
+	}
+
+	revision, err := rc.rs.Get(article.ID, version)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	article.Title = revision.Title
+	article.Description = revision.Description
+	article.Body = revision.Body
+
+	if err := rc.as.SetArticleTags(services.RevisionTags(revision), article); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	if err := rc.as.UpdateArticle(*article, current.ID); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, ArticleSerializer(article, current))
+	return
+}
+
+// getOwnedArticle loads the article from the slug param and writes
+// an error response if it does not exist or the user is not its author
+func (rc *revisionController) getOwnedArticle(c *gin.Context, current *models.User) (*models.Article, bool) {
+	slg := c.Param("slug")
+	article, err := rc.as.GetArticleBySlug(slg, current.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return nil, false
+	}
+
+	if article.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no article with that slug",
+		})
+		return nil, false
+	}
+
+	if current.ID != article.AuthorId {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "only the owner of the article is allowed to see its revisions",
+		})
+		return nil, false
+	}
+
+	return article, true
+}
+
+func RevisionSerializer(revision *models.ArticleRevision, current *models.User) models.RevisionResponse {
+	return models.RevisionResponse{
+		ID:          revision.ID,
+		CreatedAt:   revision.CreatedAt,
+		Version:     revision.Version,
+		Title:       revision.Title,
+		Description: revision.Description,
+		Body:        revision.Body,
+		TagList:     services.RevisionTags(revision),
+		Editor:      ProfileSerializer(&revision.Editor, current),
+	}
+}
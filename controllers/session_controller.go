@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+)
+
+type SessionController interface {
+	GetSessions(c *gin.Context)
+	RevokeSession(c *gin.Context)
+	RevokeAllSessions(c *gin.Context)
+}
+
+type sessionController struct {
+	as services.AuthService
+}
+
+func NewSessionController(as services.AuthService) SessionController {
+	return &sessionController{as}
+}
+
+func (sc *sessionController) GetSessions(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	list, err := sc.as.GetSessions(c.Request.Context(), authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.CreateApiError(http.StatusInternalServerError, err))
+		return
+	}
+
+	current := c.GetString("sessionId")
+	for i := range list {
+		list[i].Current = list[i].ID == current
+	}
+
+	c.JSON(http.StatusOK, list)
+	return
+}
+
+func (sc *sessionController) RevokeSession(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+	id := c.Param("id")
+
+	if err := sc.as.RevokeSession(c.Request.Context(), authUser.ID, id); err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	if id == c.GetString("sessionId") {
+		clearSession(c)
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+// RevokeAllSessions logs the user out everywhere, including the current session
+func (sc *sessionController) RevokeAllSessions(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	if err := sc.as.RevokeSessions(c.Request.Context(), authUser.ID, ""); err != nil {
+		c.JSON(utils.CreateApiError(http.StatusInternalServerError, err))
+		return
+	}
+
+	clearSession(c)
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func clearSession(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	_ = session.Save()
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TokenController interface {
+	GetTokens(c *gin.Context)
+	CreateToken(c *gin.Context)
+	RevokeToken(c *gin.Context)
+}
+
+type tokenController struct {
+	ts services.TokenService
+}
+
+func NewTokenController(ts services.TokenService) TokenController {
+	return &tokenController{ts}
+}
+
+func (tc *tokenController) GetTokens(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	tokens, err := tc.ts.List(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.AccessTokenResponse, 0)
+
+	for _, t := range *tokens {
+		response = append(response, TokenSerializer(&t))
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+type tokenRequest struct {
+	Name      string     `json:"name" binding:"required,gte=3,lte=50"`
+	Scopes    []string   `json:"scopes" binding:"omitempty,dive,oneof=read write"`
+	ExpiresAt *time.Time `json:"expiresAt" binding:"omitempty"`
+}
+
+func (tc *tokenController) CreateToken(c *gin.Context) {
+	var req tokenRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	// Tokens can only be created from a session, so a leaked token cannot be used to mint new ones
+	if _, exists := c.Get("accessToken"); exists {
+		c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("tokens cannot be created with a token")))
+		return
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"field": "expiresAt",
+			"error": "must be in the future",
+		})
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeRead, models.ScopeWrite}
+	}
+
+	authUser := c.MustGet("user").(*models.User)
+
+	token, raw, err := tc.ts.Create(authUser.ID, req.Name, scopes, req.ExpiresAt)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := TokenSerializer(token)
+	response.Token = raw
+
+	c.JSON(http.StatusCreated, response)
+	return
+}
+
+func (tc *tokenController) RevokeToken(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	if err := tc.ts.Revoke(authUser.ID, uint(id)); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func TokenSerializer(token *models.AccessToken) models.AccessTokenResponse {
+	return models.AccessTokenResponse{
+		ID:         token.ID,
+		CreatedAt:  token.CreatedAt,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     services.TokenScopes(token),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
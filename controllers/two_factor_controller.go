@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+)
+
+type TwoFactorController interface {
+	Enroll(c *gin.Context)
+	Confirm(c *gin.Context)
+	Disable(c *gin.Context)
+	RegenerateRecoveryCodes(c *gin.Context)
+}
+
+type twoFactorController struct {
+	tfs services.TwoFactorService
+}
+
+func NewTwoFactorController(tfs services.TwoFactorService) TwoFactorController {
+	return &twoFactorController{tfs}
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type enrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (tc *twoFactorController) Enroll(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	secret, uri, err := tc.tfs.Enroll(authUser)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, enrollResponse{
+		Secret: secret,
+		URI:    uri,
+	})
+	return
+}
+
+func (tc *twoFactorController) Confirm(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	var req twoFactorCodeRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	codes, err := tc.tfs.Confirm(c.Request.Context(), authUser, req.Code)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	return
+}
+
+func (tc *twoFactorController) Disable(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	var req twoFactorCodeRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	if err := tc.tfs.Disable(c.Request.Context(), authUser, req.Code); err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func (tc *twoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	var req twoFactorCodeRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	codes, err := tc.tfs.RegenerateRecoveryCodes(c.Request.Context(), authUser, req.Code)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	return
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"mime/multipart"
+	"net/http"
+)
+
+type UploadController interface {
+	CreateUpload(c *gin.Context)
+}
+
+type uploadController struct {
+	us services.UploadService
+}
+
+func NewUploadController(us services.UploadService) UploadController {
+	return &uploadController{us}
+}
+
+type uploadInput struct {
+	Image *multipart.FileHeader `form:"image" binding:"required"`
+}
+
+// CreateUpload stores an image to be embedded in the body of an article.
+// Uploads that are not used in an article are removed after a while.
+func (uc *uploadController) CreateUpload(c *gin.Context) {
+	var req uploadInput
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	if req.Image == nil {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("no image provided")))
+		return
+	}
+
+	// Validate image mime-type is allowable
+	if valid := isAllowedImageType(imageType(req.Image)); !valid {
+		e := apperrors.NewBadRequest("imageFile must be 'image/jpeg' or 'image/png'")
+		c.JSON(e.Status(), gin.H{
+			"error": e,
+		})
+		return
+	}
+
+	authUser := c.MustGet("user").(*models.User)
+
+	upload, err := uc.us.Create(authUser.ID, req.Image)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromService(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, UploadSerializer(upload))
+	return
+}
+
+func UploadSerializer(upload *models.Upload) models.UploadResponse {
+	return models.UploadResponse{
+		ID:        upload.ID,
+		CreatedAt: upload.CreatedAt,
+		URL:       upload.URL,
+		Width:     upload.Width,
+		Height:    upload.Height,
+		Variants:  ImageVariantsSerializer(upload.Variants),
+	}
+}
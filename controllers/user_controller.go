@@ -1,31 +1,55 @@
 package controllers
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	"github.com/sentrionic/OlympusGin/services"
 	"github.com/sentrionic/OlympusGin/utils"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 )
 
 type UserController interface {
 	Current(c *gin.Context)
 	Edit(c *gin.Context)
 	ChangePassword(c *gin.Context)
+	Delete(c *gin.Context)
+	Export(c *gin.Context)
 }
 
 type userController struct {
-	us services.UserService
-	fs services.FileService
+	us     services.UserService
+	as     services.AuthService
+	fs     services.FileService
+	redis  services.RedisService
+	mail   services.MailService
+	policy services.PasswordPolicy
 }
 
-func NewUserController(us services.UserService, fs services.FileService) UserController {
+func NewUserController(
+	us services.UserService,
+	as services.AuthService,
+	fs services.FileService,
+	rs services.RedisService,
+	ms services.MailService,
+	pp services.PasswordPolicy,
+) UserController {
 	return &userController{
-		us,
-		fs,
+		us:     us,
+		as:     as,
+		fs:     fs,
+		redis:  rs,
+		mail:   ms,
+		policy: pp,
 	}
 }
 
@@ -67,15 +91,24 @@ func (uc *userController) Edit(c *gin.Context) {
 
 	}
 
-	if authUser.Email != req.Email {
-		exists, err := uc.us.GetByEmail(req.Email)
+	email := strings.ToLower(req.Email)
+	emailChanged := authUser.Email != email
+
+	if emailChanged {
+		// The email is used to reset the password, so a leaked token must not be able to change it
+		if _, exists := c.Get("accessToken"); exists {
+			c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("the email cannot be changed with a token")))
+			return
+		}
+
+		exists, err := uc.us.GetByEmail(email)
 
 		if err != nil {
 			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("something went wrong")))
 			return
 		}
 
-		if exists != nil {
+		if exists.ID != 0 {
 			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("a user with that email already exists")))
 			return
 		}
@@ -84,20 +117,27 @@ func (uc *userController) Edit(c *gin.Context) {
 
 	authUser.Username = req.Username
 	authUser.Bio = req.Bio
-	authUser.Email = req.Email
 
 	if req.Image != nil {
+		// Validate image mime-type is allowable
+		if valid := isAllowedImageType(imageType(req.Image)); !valid {
+			e := apperrors.NewBadRequest("imageFile must be 'image/jpeg' or 'image/png'")
+			c.JSON(e.Status(), gin.H{
+				"error": e,
+			})
+			return
+		}
+
 		directory := fmt.Sprintf("gin/users/%d", authUser.ID)
-		url, err := uc.fs.UploadAvatar(req.Image, directory)
+		avatar, err := uc.fs.UploadAvatar(req.Image, directory)
 
 		if err != nil {
-			c.JSON(500, gin.H{
-				"error": err,
-			})
+			c.JSON(utils.ErrorFromService(err))
 			return
 		}
 
-		authUser.Image = url
+		authUser.Image = avatar.URL
+		authUser.ImageKey = avatar.Key
 	}
 
 	user, err := uc.us.Edit(*authUser)
@@ -109,13 +149,18 @@ func (uc *userController) Edit(c *gin.Context) {
 		return
 	}
 
+	// The new email only replaces the current one once it has been confirmed
+	if emailChanged {
+		sendVerificationEmail(c, uc.redis, uc.mail, user.ID, email)
+	}
+
 	c.JSON(http.StatusOK, user)
 }
 
 type changeRequest struct {
 	CurrentPassword    string `json:"currentPassword" binding:"required"`
-	NewPassword        string `json:"newPassword" binding:"required,gte=6"`
-	ConfirmNewPassword string `json:"confirmNewPassword" binding:"required,gte=6"`
+	NewPassword        string `json:"newPassword" binding:"required"`
+	ConfirmNewPassword string `json:"confirmNewPassword" binding:"required"`
 }
 
 func (uc *userController) ChangePassword(c *gin.Context) {
@@ -127,11 +172,26 @@ func (uc *userController) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// A leaked token must not be enough to take over the account
+	if _, exists := c.Get("accessToken"); exists {
+		c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("the password cannot be changed with a token")))
+		return
+	}
+
+	if !utils.CheckPassword(req.CurrentPassword, authUser.PasswordHash) {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("incorrect password")))
+		return
+	}
+
 	if req.NewPassword != req.ConfirmNewPassword {
 		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("passwords do not match")))
 		return
 	}
 
+	if valid := validatePassword(c, uc.policy, "NewPassword", req.NewPassword, authUser.Username, authUser.Email); !valid {
+		return
+	}
+
 	err := uc.us.ChangePassword(authUser.ID, req.NewPassword)
 
 	if err != nil {
@@ -141,5 +201,203 @@ func (uc *userController) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// Keep the session that changed the password, log out everything else
+	current := c.GetString("sessionId")
+	if err := uc.as.RevokeSessions(c.Request.Context(), authUser.ID, current); err != nil {
+		fmt.Printf("error revoking sessions: %v", err)
+	}
+
 	c.JSON(http.StatusOK, authUser)
 }
+
+type deleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// Delete removes the account of the current user after they re-entered their password
+func (uc *userController) Delete(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	var req deleteAccountRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	// A leaked token must not be enough to delete the account
+	if _, exists := c.Get("accessToken"); exists {
+		c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("accounts cannot be deleted with a token")))
+		return
+	}
+
+	if !utils.CheckPassword(req.Password, authUser.PasswordHash) {
+		c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("incorrect password")))
+		return
+	}
+
+	images, err := uc.us.Delete(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	for _, image := range images {
+		if err := uc.fs.DeleteImage(image); err != nil {
+			fmt.Printf("error deleting image: %v", err)
+		}
+	}
+
+	if err := uc.as.RevokeSessions(c.Request.Context(), authUser.ID, ""); err != nil {
+		fmt.Printf("error revoking sessions: %v", err)
+	}
+
+	c.Set("user", nil)
+	clearSession(c)
+
+	c.JSON(http.StatusOK, true)
+}
+
+type exportArticle struct {
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Body        string     `json:"body"`
+	Image       string     `json:"image"`
+	Status      string     `json:"status"`
+	TagList     []string   `json:"tagList"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	PublishedAt *time.Time `json:"publishedAt"`
+}
+
+type exportComment struct {
+	ID        uint       `json:"id"`
+	Article   string     `json:"article"`
+	ParentID  *uint      `json:"parentId"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt"`
+}
+
+type exportArticleRef struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+// Export returns a ZIP archive with everything stored about the current user
+func (uc *userController) Export(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	data, err := uc.us.Export(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	articles := make([]exportArticle, 0)
+	images := make(map[string]string)
+
+	if data.User.ImageKey != "" {
+		images["images/avatar"+path.Ext(data.User.ImageKey)] = data.User.ImageKey
+	}
+
+	for _, a := range data.Articles {
+		tagList := make([]string, 0)
+		for _, tag := range a.Tags {
+			tagList = append(tagList, tag.Tag)
+		}
+
+		articles = append(articles, exportArticle{
+			Slug:        a.Slug,
+			Title:       a.Title,
+			Description: a.Description,
+			Body:        a.Body,
+			Image:       a.Image,
+			Status:      a.Status,
+			TagList:     tagList,
+			CreatedAt:   a.CreatedAt,
+			UpdatedAt:   a.UpdatedAt,
+			PublishedAt: a.PublishedAt,
+		})
+
+		if a.ImageKey != "" {
+			images["images/articles/"+a.Slug+"-"+path.Base(a.ImageKey)] = a.ImageKey
+		}
+	}
+
+	comments := make([]exportComment, 0)
+	for _, comment := range data.Comments {
+		comments = append(comments, exportComment{
+			ID:        comment.ID,
+			Article:   comment.Article.Slug,
+			ParentID:  comment.ParentID,
+			Body:      comment.Body,
+			CreatedAt: comment.CreatedAt,
+			EditedAt:  comment.EditedAt,
+		})
+	}
+
+	files := map[string]interface{}{
+		"profile.json":   data.User,
+		"articles.json":  articles,
+		"comments.json":  comments,
+		"favorites.json": exportArticleRefs(data.Favorites),
+		"bookmarks.json": exportArticleRefs(data.Bookmarks),
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"olympus-%s.zip\"", data.User.Username))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+
+	for name, value := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			fmt.Printf("error writing export: %v", err)
+			return
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			fmt.Printf("error writing export: %v", err)
+			return
+		}
+	}
+
+	// Images that are missing from the storage are skipped
+	for name, image := range images {
+		body, err := uc.fs.GetImage(image)
+		if err != nil {
+			continue
+		}
+
+		w, err := archive.Create(name)
+		if err == nil {
+			_, err = io.Copy(w, body)
+		}
+		body.Close()
+
+		if err != nil {
+			fmt.Printf("error writing export: %v", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		fmt.Printf("error writing export: %v", err)
+	}
+}
+
+func exportArticleRefs(articles []models.Article) []exportArticleRef {
+	refs := make([]exportArticleRef, 0)
+	for _, a := range articles {
+		refs = append(refs, exportArticleRef{
+			Slug:  a.Slug,
+			Title: a.Title,
+		})
+	}
+	return refs
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type WebhookController interface {
+	GetWebhooks(c *gin.Context)
+	CreateWebhook(c *gin.Context)
+	DeleteWebhook(c *gin.Context)
+	GetDeliveries(c *gin.Context)
+}
+
+type webhookController struct {
+	ws services.WebhookService
+}
+
+func NewWebhookController(ws services.WebhookService) WebhookController {
+	return &webhookController{ws}
+}
+
+func (wc *webhookController) GetWebhooks(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	webhooks, err := wc.ws.List(authUser.ID)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.WebhookResponse, 0)
+
+	for _, w := range *webhooks {
+		response = append(response, WebhookSerializer(&w))
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,lte=2048"`
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=article.published article.updated article.deleted comment.created"`
+	Global bool     `json:"global"`
+}
+
+func (wc *webhookController) CreateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if valid := bindData(c, &req); !valid {
+		return
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"field": "url",
+			"error": "must be an http or https URL",
+		})
+		return
+	}
+
+	authUser := c.MustGet("user").(*models.User)
+
+	if req.Global && !models.HasPermission(authUser.Role, models.PermManageWebhooks) {
+		c.JSON(utils.CreateApiError(http.StatusForbidden, errors.New("only admins can create global webhooks")))
+		return
+	}
+
+	webhook, err := wc.ws.Create(authUser.ID, req.URL, uniqueEvents(req.Events), req.Global)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	// The secret is only shown once, when the webhook is created
+	response := WebhookSerializer(webhook)
+	response.Secret = webhook.Secret
+
+	c.JSON(http.StatusCreated, response)
+	return
+}
+
+func (wc *webhookController) DeleteWebhook(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	if err := wc.ws.Delete(authUser.ID, uint(id)); err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+	return
+}
+
+func (wc *webhookController) GetDeliveries(c *gin.Context) {
+	authUser := c.MustGet("user").(*models.User)
+
+	param := c.Param("id")
+	id, _ := strconv.Atoi(param)
+
+	webhook, err := wc.ws.Get(authUser.ID, uint(id))
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	page := 0
+	pageQuery := c.Query("p")
+	if pageQuery != "" {
+		p, err := strconv.Atoi(pageQuery)
+		if err != nil {
+			c.JSON(utils.CreateApiError(http.StatusBadRequest, errors.New("invalid page query parameter")))
+			return
+		}
+		page = p
+	}
+
+	deliveries, err := wc.ws.Deliveries(webhook.ID, LIMIT, page)
+
+	if err != nil {
+		c.JSON(utils.ErrorFromDatabase(err))
+		return
+	}
+
+	response := make([]models.WebhookDeliveryResponse, 0)
+
+	for _, d := range *deliveries {
+		response = append(response, WebhookDeliverySerializer(&d))
+	}
+
+	c.JSON(http.StatusOK, response)
+	return
+}
+
+func uniqueEvents(events []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(events))
+
+	for _, e := range events {
+		if !seen[e] {
+			seen[e] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+func WebhookSerializer(w *models.Webhook) models.WebhookResponse {
+	return models.WebhookResponse{
+		ID:        w.ID,
+		CreatedAt: w.CreatedAt,
+		URL:       w.URL,
+		Events:    strings.Split(w.Events, ","),
+		Global:    w.Global,
+		Active:    w.Active,
+	}
+}
+
+func WebhookDeliverySerializer(d *models.WebhookDelivery) models.WebhookDeliveryResponse {
+	return models.WebhookDeliveryResponse{
+		ID:             d.ID,
+		CreatedAt:      d.CreatedAt,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastAttemptAt:  d.LastAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		Error:          d.Error,
+	}
+}
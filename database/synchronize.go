@@ -3,6 +3,7 @@ package database
 import (
 	"github.com/go-gormigrate/gormigrate/v2"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/utils"
 	"gorm.io/gorm"
 )
 
@@ -30,7 +31,327 @@ func synchronize(db *gorm.DB) error {
 				)
 			},
 		},
+		{
+			ID: "Add Article Status",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Article{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.Article{}, "Status")
+			},
+		},
+		{
+			ID: "Add Article Scheduling",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.Article{}); err != nil {
+					return err
+				}
+				return tx.Exec("UPDATE articles SET published_at = created_at WHERE status <> ? AND published_at IS NULL", models.StatusDraft).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&models.Article{}, "PublishAt"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.Article{}, "PublishedAt")
+			},
+		},
+		{
+			ID: "Add Article Revisions",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.ArticleRevision{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.ArticleRevision{})
+			},
+		},
+		{
+			ID: "Add Article Search",
+			Migrate: func(tx *gorm.DB) error {
+				for _, statement := range articleSearchMigration {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, statement := range articleSearchRollback {
+					if err := tx.Exec(statement).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "Add Comment Threads",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Comment{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, column := range []string{"ParentID", "Depth", "Deleted"} {
+					if err := tx.Migrator().DropColumn(&models.Comment{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			ID: "Add Comment Edits",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Comment{}, &models.CommentEdit{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&models.CommentEdit{}); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.Comment{}, "EditedAt")
+			},
+		},
+		{
+			ID: "Add User Roles",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.User{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.User{}, "Role")
+			},
+		},
+		{
+			ID: "Add Access Tokens",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AccessToken{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.AccessToken{})
+			},
+		},
+		{
+			ID: "Add Email Verification",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.User{}); err != nil {
+					return err
+				}
+				// Accounts that existed before verification was required keep their permissions
+				return tx.Exec("UPDATE users SET email_verified = true").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.User{}, "EmailVerified")
+			},
+		},
+		{
+			ID: "Add Two Factor Authentication",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.User{}, &models.RecoveryCode{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&models.RecoveryCode{}); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&models.User{}, "TOTPSecret"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.User{}, "TOTPEnabled")
+			},
+		},
+		{
+			ID: "Add Notifications",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.Notification{}, &models.NotificationActor{}); err != nil {
+					return err
+				}
+				// Only one unread notification per group, which Notify upserts into
+				return tx.Exec(`CREATE UNIQUE INDEX idx_notifications_unread_group
+					ON notifications (user_id, type, (COALESCE(article_id, 0))) WHERE read_at IS NULL`).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.NotificationActor{}, &models.Notification{})
+			},
+		},
+		{
+			ID: "Add Webhooks",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Webhook{}, &models.WebhookDelivery{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.WebhookDelivery{}, &models.Webhook{})
+			},
+		},
+		{
+			ID: "Add Image Variants",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Article{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&models.Article{}, "ImageVariants")
+			},
+		},
+		{
+			ID: "Add Uploads",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Upload{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.Upload{})
+			},
+		},
+		{
+			ID: "Add Image Keys",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.Article{}, &models.User{}); err != nil {
+					return err
+				}
+				return migrateImageKeys(tx)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&models.Article{}, "ImageKey"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&models.User{}, "ImageKey")
+			},
+		},
+		{
+			ID: "Add Article Uploads",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.Upload{}, &models.ArticleUpload{}); err != nil {
+					return err
+				}
+				return tx.Exec(articleUploadsMigration).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&models.ArticleUpload{})
+			},
+		},
 	})
 
 	return m.Migrate()
 }
+
+// articleSearchMigration adds a weighted tsvector to articles. Title and tags
+// rank highest, then the description and finally the body. The vector is
+// recomputed by a trigger whenever an article row or its tags change.
+var articleSearchMigration = []string{
+	`ALTER TABLE articles ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_articles_search_vector ON articles USING GIN (search_vector)`,
+	`CREATE OR REPLACE FUNCTION articles_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector :=
+			setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce((
+				SELECT string_agg(tags.tag, ' ')
+				FROM article_tags JOIN tags ON tags.id = article_tags.tag_id
+				WHERE article_tags.article_id = NEW.id
+			), '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(NEW.description, '')), 'B') ||
+			setweight(to_tsvector('english', coalesce(NEW.body, '')), 'C');
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS articles_search_vector_trigger ON articles`,
+	`CREATE TRIGGER articles_search_vector_trigger BEFORE INSERT OR UPDATE ON articles
+	FOR EACH ROW EXECUTE PROCEDURE articles_search_vector_update()`,
+	`CREATE OR REPLACE FUNCTION article_tags_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			UPDATE articles SET search_vector = NULL WHERE id = OLD.article_id;
+			RETURN OLD;
+		END IF;
+		UPDATE articles SET search_vector = NULL WHERE id = NEW.article_id;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS article_tags_search_vector_trigger ON article_tags`,
+	`CREATE TRIGGER article_tags_search_vector_trigger AFTER INSERT OR DELETE ON article_tags
+	FOR EACH ROW EXECUTE PROCEDURE article_tags_search_vector_update()`,
+	`UPDATE articles SET search_vector = NULL`,
+}
+
+var articleSearchRollback = []string{
+	`DROP TRIGGER IF EXISTS article_tags_search_vector_trigger ON article_tags`,
+	`DROP FUNCTION IF EXISTS article_tags_search_vector_update()`,
+	`DROP TRIGGER IF EXISTS articles_search_vector_trigger ON articles`,
+	`DROP FUNCTION IF EXISTS articles_search_vector_update()`,
+	`ALTER TABLE articles DROP COLUMN IF EXISTS search_vector`,
+}
+
+// articleUploadsMigration records the uploads the existing articles and their revisions embed
+const articleUploadsMigration = `
+	INSERT INTO article_uploads (article_id, upload_id)
+	SELECT articles.id, uploads.id FROM articles JOIN uploads ON strpos(articles.body, uploads.key_prefix) > 0
+	UNION
+	SELECT article_revisions.article_id, uploads.id FROM article_revisions JOIN uploads ON strpos(article_revisions.body, uploads.key_prefix) > 0
+	ON CONFLICT DO NOTHING`
+
+// imageKeyPrefix is where the files uploaded by users are stored
+const imageKeyPrefix = "files/gin/users/"
+
+// imageKeyBatch is how many rows migrateImageKeys loads at once
+const imageKeyBatch = 500
+
+// migrateImageKeys derives the storage keys of the existing images from their URLs.
+// The URLs escape the keys, so they are decoded in Go instead of being cut out in SQL.
+// Only files uploaded by users get a key, placeholders like gravatar or picsum keep none.
+func migrateImageKeys(tx *gorm.DB) error {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	var users []models.User
+	err := tx.
+		Select("id", "image").
+		Where("image LIKE ?", "%/"+imageKeyPrefix+"%").
+		FindInBatches(&users, imageKeyBatch, func(_ *gorm.DB, _ int) error {
+			for _, u := range users {
+				key := utils.KeyFromURL(u.Image, imageKeyPrefix)
+				if err := db.Model(&models.User{}).Where("id = ?", u.ID).UpdateColumn("image_key", key).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+
+	if err != nil {
+		return err
+	}
+
+	var articles []models.Article
+	err = tx.
+		Select("id", "image", "image_variants").
+		Where("image LIKE ? OR jsonb_array_length(image_variants) > 0", "%/"+imageKeyPrefix+"%").
+		FindInBatches(&articles, imageKeyBatch, func(_ *gorm.DB, _ int) error {
+			for _, a := range articles {
+				err := db.Model(&models.Article{}).Where("id = ?", a.ID).UpdateColumns(map[string]interface{}{
+					"image_key":      utils.KeyFromURL(a.Image, imageKeyPrefix),
+					"image_variants": variantKeys(a.ImageVariants),
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+
+	if err != nil {
+		return err
+	}
+
+	var uploads []models.Upload
+	return tx.
+		Select("id", "variants").
+		Where("jsonb_array_length(variants) > 0").
+		FindInBatches(&uploads, imageKeyBatch, func(_ *gorm.DB, _ int) error {
+			for _, u := range uploads {
+				err := db.Model(&models.Upload{}).Where("id = ?", u.ID).UpdateColumn("variants", variantKeys(u.Variants)).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+func variantKeys(variants models.ImageVariants) models.ImageVariants {
+	for i := range variants {
+		variants[i].Key = utils.KeyFromURL(variants[i].URL, imageKeyPrefix)
+	}
+	return variants
+}
@@ -1,46 +1,107 @@
 package main
 
 import (
+	"context"
 	"github.com/sentrionic/OlympusGin/config"
 	"github.com/sentrionic/OlympusGin/controllers"
 	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/routes"
 	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
 )
 
 func main() {
 
 	// Config
 	c := config.NewConfig()
-	r := routes.NewRouter(c)
 	mail := services.NewMailService(c)
 	file := services.NewFileService(c)
 	conn := database.NewDatabaseConnection(c)
 	redis := database.NewRedisConnection(c)
+	r := routes.NewRouter(c, redis)
+	if err := utils.SetArgon2Params(argon2Params(c)); err != nil {
+		panic("invalid password.argon2 config: " + err.Error())
+	}
 
 	// Services
 	rs := services.NewRedisService(redis)
-	aus := services.NewAuthService(conn)
-	us := services.NewUserService(conn)
-	ps := services.NewProfileService(conn)
-	ars := services.NewArticleService(conn)
-	cs := services.NewCommentService(conn)
+	ws := services.NewWebhookService(conn, c)
+	es := services.NewEventService(redis, c)
+	ns := services.NewNotificationService(conn, es)
+	tfs := services.NewTwoFactorService(conn, redis)
+	aus := services.NewAuthService(conn, redis, mail, tfs)
+	us := services.NewUserService(conn, ws)
+	ps := services.NewProfileService(conn, ns)
+	ars := services.NewArticleService(conn, c, ns, es, ws)
+	cs := services.NewCommentService(conn, c, ns, ws)
+	rvs := services.NewRevisionService(conn)
+	ts := services.NewTokenService(conn)
+	pp := services.NewPasswordPolicy(c)
+	ups := services.NewUploadService(conn, file, c)
+
+	// Workers
+	scheduler := services.NewPublishScheduler(ars, c)
+	scheduler.Start(context.Background())
+	es.Start(context.Background())
+	dispatcher := services.NewWebhookDispatcher(ws, c)
+	dispatcher.Start(context.Background())
+	cleaner := services.NewUploadCleaner(ups, c)
+	cleaner.Start(context.Background())
 
 	// Controllers
-	au := controllers.NewAuthController(aus, rs, mail)
-	uc := controllers.NewUserController(us, file)
+	au := controllers.NewAuthController(aus, tfs, rs, mail, pp)
+	uc := controllers.NewUserController(us, aus, file, rs, mail, pp)
 	pc := controllers.NewProfileController(ps)
 	ac := controllers.NewArticleController(ars, file)
-	cc := controllers.NewCommentController(cs, ars)
+	cc := controllers.NewCommentController(cs, ars, es)
+	rc := controllers.NewRevisionController(rvs, ars)
+	adc := controllers.NewAdminController(us)
+	tc := controllers.NewTokenController(ts)
+	sc := controllers.NewSessionController(aus)
+	tfc := controllers.NewTwoFactorController(tfs)
+	nc := controllers.NewNotificationController(ns)
+	ec := controllers.NewEventController(es, ars, ps)
+	wc := controllers.NewWebhookController(ws)
+	fc := controllers.NewFileController(file)
+	upc := controllers.NewUploadController(ups)
 
 	// Routes
-	r.RegisterAuthRoutes(au)
+	r.RegisterAuthRoutes(au, aus)
 	r.RegisterUserRoutes(uc, aus)
 	r.RegisterProfileRoutes(pc, aus)
 	r.RegisterArticleRoutes(ac, aus)
 	r.RegisterCommentRoutes(cc, aus)
+	r.RegisterRevisionRoutes(rc, aus)
+	r.RegisterAdminRoutes(adc, aus)
+	r.RegisterTokenRoutes(tc, aus)
+	r.RegisterSessionRoutes(sc, aus)
+	r.RegisterTwoFactorRoutes(tfc, aus)
+	r.RegisterNotificationRoutes(nc, aus)
+	r.RegisterEventRoutes(ec, aus)
+	r.RegisterWebhookRoutes(wc, aus)
+	r.RegisterFileRoutes(fc)
+	r.RegisterUploadRoutes(upc, aus)
 
 	if err := r.Serve(); err != nil {
 		panic("error serving routes")
 	}
 }
+
+// argon2Params reads the password hashing cost from the config.
+// Unset values keep their default.
+func argon2Params(c *config.Config) utils.Argon2Params {
+	cfg := c.Get()
+	p := utils.DefaultArgon2Params
+
+	if cfg.IsSet("password.argon2.memory") {
+		p.Memory = cfg.GetUint32("password.argon2.memory")
+	}
+	if cfg.IsSet("password.argon2.iterations") {
+		p.Iterations = cfg.GetUint32("password.argon2.iterations")
+	}
+	if cfg.IsSet("password.argon2.parallelism") {
+		p.Parallelism = uint8(cfg.GetUint("password.argon2.parallelism"))
+	}
+
+	return p
+}
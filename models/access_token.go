@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Scopes an access token can be limited to
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// AccessToken is a personal token for clients that cannot use the session cookie.
+// Only the SHA-256 hash of the token is stored.
+type AccessToken struct {
+	BaseModel
+	User       User
+	UserID     uint `gorm:"index"`
+	Name       string
+	Prefix     string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+type AccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	Token      string     `json:"token,omitempty"`
+}
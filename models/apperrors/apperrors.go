@@ -3,7 +3,9 @@ package apperrors
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"time"
 )
 
 // Type holds a type string and integer code for the error
@@ -14,17 +16,20 @@ const (
 	Authorization   Type = "AUTHORIZATION"   // Authentication Failures -
 	BadRequest      Type = "BADREQUEST"      // Validation errors / BadInput
 	Conflict        Type = "CONFLICT"        // Already exists (eg, create account with existent email) - 409
+	Forbidden       Type = "FORBIDDEN"       // Authenticated but missing the permission - 403
 	Internal        Type = "INTERNAL"        // Server (500) and fallback errors
 	NotFound        Type = "NOTFOUND"        // For not finding resource
 	PayloadTooLarge Type = "PAYLOADTOOLARGE" // for uploading tons of JSON, or an image over the limit - 413
+	TooManyRequests Type = "TOOMANYREQUESTS" // Rate limited or locked out - 429
 )
 
 // Error holds a custom error for the application
 // which is helpful in returning a consistent
 // error type/message from API endpoints
 type Error struct {
-	Type    Type   `json:"type"`
-	Message string `json:"message"`
+	Type       Type   `json:"type"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
 }
 
 // Error satisfies standard error interface
@@ -45,12 +50,16 @@ func (e *Error) Status() int {
 		return http.StatusBadRequest
 	case Conflict:
 		return http.StatusConflict
+	case Forbidden:
+		return http.StatusForbidden
 	case Internal:
 		return http.StatusInternalServerError
 	case NotFound:
 		return http.StatusNotFound
 	case PayloadTooLarge:
 		return http.StatusRequestEntityTooLarge
+	case TooManyRequests:
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
@@ -95,6 +104,14 @@ func NewConflict(name string, value string) *Error {
 	}
 }
 
+// NewForbidden to create a 403
+func NewForbidden(reason string) *Error {
+	return &Error{
+		Type:    Forbidden,
+		Message: reason,
+	}
+}
+
 // NewInternal for 500 errors and unknown errors
 func NewInternal() *Error {
 	return &Error{
@@ -118,3 +135,23 @@ func NewPayloadTooLarge(maxBodySize int64, contentLength int64) *Error {
 		Message: fmt.Sprintf("Max payload size of %v exceeded. Actual payload size: %v", maxBodySize, contentLength),
 	}
 }
+
+// NewImageTooLarge to create an error for 413 when the dimensions of an image
+// exceed the limit, which protects the server from decompression bombs
+func NewImageTooLarge(maxPixels int64, width int, height int) *Error {
+	return &Error{
+		Type:    PayloadTooLarge,
+		Message: fmt.Sprintf("Image dimensions of %dx%d exceed the limit of %v pixels", width, height, maxPixels),
+	}
+}
+
+// NewTooManyRequests to create an error for 429
+// retryAfter is rounded up to full seconds
+func NewTooManyRequests(retryAfter time.Duration) *Error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	return &Error{
+		Type:       TooManyRequests,
+		Message:    fmt.Sprintf("Too many requests. Try again in %v seconds", seconds),
+		RetryAfter: seconds,
+	}
+}
@@ -2,32 +2,52 @@ package models
 
 import "time"
 
+// Article visibility states. Drafts are only visible to their author,
+// unlisted articles can be opened by slug but never show up in lists.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusUnlisted  = "unlisted"
+)
+
 type Article struct {
 	BaseModel
-	Slug        string `gorm:"uniqueIndex"`
-	Title       string
-	Description string
-	Body        string `gorm:"type:text"`
-	Image       string
-	Author      User
-	AuthorId    uint
-	Tags        []Tag  `gorm:"many2many:article_tags"`
-	Favorites   []User `gorm:"many2many:article_favorites"`
-	Bookmarks   []User `gorm:"many2many:article_bookmarks"`
+	Slug          string `gorm:"uniqueIndex"`
+	Title         string
+	Description   string
+	Body          string `gorm:"type:text"`
+	Image         string
+	ImageKey      string
+	ImageVariants ImageVariants `gorm:"type:jsonb"`
+	Status        string        `gorm:"default:published;index"`
+	PublishAt     *time.Time    `gorm:"index"`
+	PublishedAt   *time.Time
+	Author        User
+	AuthorId      uint
+	Tags          []Tag  `gorm:"many2many:article_tags"`
+	Favorites     []User `gorm:"many2many:article_favorites"`
+	Bookmarks     []User `gorm:"many2many:article_bookmarks"`
+	SortKey       string `gorm:"->;-:migration"`
+	Headline      string `gorm:"->;-:migration"`
 }
 
 type ArticleResponse struct {
-	ID             uint      `json:"id"`
-	CreatedAt      time.Time `json:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt"`
-	Slug           string    `json:"slug"`
-	Title          string    `json:"title"`
-	Description    string    `json:"description"`
-	Body           string    `json:"body"`
-	Image          string    `json:"image"`
-	TagList        []string  `json:"tagList"`
-	Favorited      bool      `json:"favorited"`
-	Bookmarked     bool      `json:"bookmarked"`
-	FavoritesCount int       `json:"favoritesCount"`
-	Author         Profile   `json:"author"`
+	ID             uint                   `json:"id"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	UpdatedAt      time.Time              `json:"updatedAt"`
+	Slug           string                 `json:"slug"`
+	Title          string                 `json:"title"`
+	Description    string                 `json:"description"`
+	Body           string                 `json:"body"`
+	Image          string                 `json:"image"`
+	ImageVariants  []ImageVariantResponse `json:"imageVariants"`
+	Status         string                 `json:"status"`
+	PublishAt      *time.Time             `json:"publishAt"`
+	PublishedAt    *time.Time             `json:"publishedAt"`
+	TagList        []string               `json:"tagList"`
+	Favorited      bool                   `json:"favorited"`
+	Bookmarked     bool                   `json:"bookmarked"`
+	FavoritesCount int                    `json:"favoritesCount"`
+	Author         Profile                `json:"author"`
+	Highlight      string                 `json:"highlight,omitempty"`
 }
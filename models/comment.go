@@ -2,6 +2,12 @@ package models
 
 import "time"
 
+// MaxCommentDepth is the deepest level a reply can be nested at
+const MaxCommentDepth = 5
+
+// CommentEditWindow is how long after posting a comment its author can still edit it
+const CommentEditWindow = 15 * time.Minute
+
 type Comment struct {
 	BaseModel
 	Body      string
@@ -9,12 +15,39 @@ type Comment struct {
 	AuthorID  uint
 	Article   Article
 	ArticleID uint
+	ParentID  *uint `gorm:"index"`
+	Depth     int
+	Deleted   bool
+	EditedAt  *time.Time
+}
+
+// CommentEdit keeps the body a comment had before it was edited
+type CommentEdit struct {
+	BaseModel
+	Comment   Comment
+	CommentID uint `gorm:"index"`
+	Body      string
+	Editor    User
+	EditorID  uint
 }
 
 type CommentResponse struct {
+	ID        uint              `json:"id"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	Body      string            `json:"body"`
+	Author    Profile           `json:"author"`
+	ParentID  *uint             `json:"parentId"`
+	Depth     int               `json:"depth"`
+	Deleted   bool              `json:"deleted"`
+	Edited    bool              `json:"edited"`
+	EditedAt  *time.Time        `json:"editedAt"`
+	Replies   []CommentResponse `json:"replies,omitempty"`
+}
+
+type CommentEditResponse struct {
 	ID        uint      `json:"id"`
 	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
 	Body      string    `json:"body"`
-	Author    Profile   `json:"author"`
+	Editor    Profile   `json:"editor"`
 }
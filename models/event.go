@@ -0,0 +1,21 @@
+package models
+
+import "encoding/json"
+
+// Event types pushed to the clients
+const (
+	EventComment      = "comment"
+	EventNotification = "notification"
+	EventFeed         = "feed"
+	// EventReset tells the client that events were missed and it has to refetch
+	EventReset = "reset"
+)
+
+// Event is a message on the event stream. Topic decides which connections
+// receive it, e.g. user:{id}, article:{id} or feed:{authorId}.
+type Event struct {
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// UserExport holds everything stored about a user for the data export
+type UserExport struct {
+	User      User
+	Articles  []Article
+	Comments  []Comment
+	Favorites []Article
+	Bookmarks []Article
+}
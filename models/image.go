@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ImageVariant is one width and format of an uploaded image
+type ImageVariant struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ImageVariants are stored as a JSON column, so that clients get
+// everything they need to build a srcset together with the article
+type ImageVariants []ImageVariant
+
+func (v ImageVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (v *ImageVariants) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, v)
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	default:
+		return fmt.Errorf("cannot scan %T into ImageVariants", value)
+	}
+}
+
+type ImageVariantResponse struct {
+	URL    string `json:"url"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Notification types
+const (
+	NotificationFollow   = "follow"
+	NotificationFavorite = "favorite"
+	NotificationComment  = "comment"
+)
+
+// Notification groups similar events for a user. While it is unread, further
+// events of the same type on the same article are added to it instead of
+// creating new notifications. Actor is the most recent one.
+type Notification struct {
+	BaseModel
+	User       User
+	UserID     uint `gorm:"index"`
+	Type       string
+	Article    *Article
+	ArticleID  *uint
+	Actor      User
+	ActorID    uint
+	ActorCount int
+	ReadAt     *time.Time
+}
+
+// NotificationActor records who took part in a notification, so that
+// repeated events by the same user are only counted once
+type NotificationActor struct {
+	NotificationID uint `gorm:"primaryKey"`
+	ActorID        uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+}
+
+type NotificationArticle struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+type NotificationResponse struct {
+	ID         uint                 `json:"id"`
+	CreatedAt  time.Time            `json:"createdAt"`
+	UpdatedAt  time.Time            `json:"updatedAt"`
+	Type       string               `json:"type"`
+	Message    string               `json:"message"`
+	Actor      Profile              `json:"actor"`
+	ActorCount int                  `json:"actorCount"`
+	Article    *NotificationArticle `json:"article"`
+	Read       bool                 `json:"read"`
+}
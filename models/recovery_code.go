@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RecoveryCode is a one-time code that replaces the TOTP code when the
+// authenticator is lost. Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	BaseModel
+	User     User
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"uniqueIndex"`
+	UsedAt   *time.Time
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ArticleRevision is an immutable snapshot of an article's content,
+// written every time the article is created, updated or restored
+type ArticleRevision struct {
+	BaseModel
+	Article     Article
+	ArticleID   uint `gorm:"uniqueIndex:idx_article_version"`
+	Version     int  `gorm:"uniqueIndex:idx_article_version"`
+	Title       string
+	Description string
+	Body        string `gorm:"type:text"`
+	Tags        string `gorm:"type:text"`
+	Editor      User
+	EditorID    uint
+}
+
+type RevisionResponse struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Version     int       `json:"version"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Body        string    `json:"body"`
+	TagList     []string  `json:"tagList"`
+	Editor      Profile   `json:"editor"`
+}
@@ -0,0 +1,64 @@
+package models
+
+// Roles a user can have
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission names an action that is not restricted to the owner of a resource
+type Permission string
+
+const (
+	PermDeleteAnyComment    Permission = "comments:delete"
+	PermViewCommentHistory  Permission = "comments:history"
+	PermUnpublishAnyArticle Permission = "articles:unpublish"
+	PermManageUsers         Permission = "users:manage"
+	PermManageWebhooks      Permission = "webhooks:manage"
+)
+
+// rolePermissions is the permission table for every role. Roles do not
+// inherit from each other, so every permission has to be listed explicitly.
+var rolePermissions = map[string][]Permission{
+	RoleUser: {},
+	RoleModerator: {
+		PermDeleteAnyComment,
+		PermViewCommentHistory,
+		PermUnpublishAnyArticle,
+	},
+	RoleAdmin: {
+		PermDeleteAnyComment,
+		PermViewCommentHistory,
+		PermUnpublishAnyArticle,
+		PermManageUsers,
+		PermManageWebhooks,
+	},
+}
+
+// IsValidRole checks if the role exists in the permission table
+func IsValidRole(role string) bool {
+	_, exists := rolePermissions[role]
+	return exists
+}
+
+// RolesWithPermission returns every role that has been granted the permission
+func RolesWithPermission(permission Permission) []string {
+	roles := make([]string, 0)
+	for role := range rolePermissions {
+		if HasPermission(role, permission) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasPermission checks if the role has been granted the permission
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
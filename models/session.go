@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session holds the metadata of a login session. The session itself
+// lives in the cookie store, this is only used to list and revoke them.
+type Session struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"`
+}
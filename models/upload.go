@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Upload is an image uploaded to be embedded in the body of an article.
+// Uploads that no article references are removed after a while.
+type Upload struct {
+	BaseModel
+	User      User
+	UserID    uint   `gorm:"index"`
+	KeyPrefix string `gorm:"index"`
+	URL       string
+	Width     int
+	Height    int
+	Size      int64
+	Variants  ImageVariants `gorm:"type:jsonb"`
+}
+
+// ArticleUpload records that an article or one of its revisions embeds an upload.
+// The rows are added whenever an article is saved and removed with the article.
+type ArticleUpload struct {
+	ArticleID uint `gorm:"primaryKey"`
+	UploadID  uint `gorm:"primaryKey;index"`
+}
+
+type UploadResponse struct {
+	ID        uint                   `json:"id"`
+	CreatedAt time.Time              `json:"createdAt"`
+	URL       string                 `json:"url"`
+	Width     int                    `json:"width"`
+	Height    int                    `json:"height"`
+	Variants  []ImageVariantResponse `json:"variants"`
+}
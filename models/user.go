@@ -2,11 +2,16 @@ package models
 
 type User struct {
 	BaseModel
-	Username     string  `gorm:"column:username;uniqueIndex" json:"username"`
-	Email        string  `gorm:"column:email;uniqueIndex" json:"email"`
-	Bio          string  `gorm:"column:bio;size:1024" json:"bio"`
-	Image        string  `gorm:"column:image" json:"image"`
-	PasswordHash string  `gorm:"column:password;not null" json:"-"`
-	Followers    []*User `gorm:"many2many:followers" json:"-"`
-	Followee     []*User `gorm:"many2many:followee" json:"-"`
+	Username      string  `gorm:"column:username;uniqueIndex" json:"username"`
+	Email         string  `gorm:"column:email;uniqueIndex" json:"email"`
+	EmailVerified bool    `gorm:"column:email_verified;default:false" json:"emailVerified"`
+	Bio           string  `gorm:"column:bio;size:1024" json:"bio"`
+	Image         string  `gorm:"column:image" json:"image"`
+	ImageKey      string  `gorm:"column:image_key" json:"-"`
+	PasswordHash  string  `gorm:"column:password;not null" json:"-"`
+	Role          string  `gorm:"column:role;default:user" json:"role"`
+	TOTPSecret    string  `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabled   bool    `gorm:"column:totp_enabled;default:false" json:"twoFactorEnabled"`
+	Followers     []*User `gorm:"many2many:followers" json:"-"`
+	Followee      []*User `gorm:"many2many:followee" json:"-"`
 }
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Events a webhook can subscribe to
+const (
+	WebhookArticlePublished = "article.published"
+	WebhookArticleUpdated   = "article.updated"
+	WebhookArticleDeleted   = "article.deleted"
+	WebhookCommentCreated   = "comment.created"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookArticlePublished,
+	WebhookArticleUpdated,
+	WebhookArticleDeleted,
+	WebhookCommentCreated,
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint that receives the events of its owner's content.
+// Global webhooks receive the events of all content and can only be created by admins.
+type Webhook struct {
+	BaseModel
+	User   User
+	UserID uint `gorm:"index"`
+	URL    string
+	Secret string
+	Events string
+	Global bool
+	Active bool `gorm:"default:true"`
+}
+
+// WebhookDelivery is a queued event for a webhook and its delivery log
+type WebhookDelivery struct {
+	BaseModel
+	Webhook        Webhook
+	WebhookID      uint `gorm:"index"`
+	Event          string
+	Payload        string `gorm:"type:text"`
+	Status         string `gorm:"default:pending"`
+	Attempts       int
+	NextAttemptAt  *time.Time `gorm:"index"`
+	LastAttemptAt  *time.Time
+	ResponseStatus int
+	Error          string
+}
+
+type WebhookResponse struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Global    bool      `json:"global"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             uint       `json:"id"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt"`
+	ResponseStatus int        `json:"responseStatus"`
+	Error          string     `json:"error"`
+}
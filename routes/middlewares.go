@@ -1,29 +1,55 @@
 package routes
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	"github.com/sentrionic/OlympusGin/services"
+	"github.com/sentrionic/OlympusGin/utils"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
 func AuthUser(as services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		id := session.Get("userId")
+		if token := bearerToken(c); token != "" {
+			user, accessToken, err := as.GetByAccessToken(token)
 
-		if id == nil {
-			err := errors.New("provided session is invalid")
-			c.JSON(401, gin.H{
-				"error": err,
-			})
-			c.Abort()
+			if err != nil {
+				e := apperrors.NewAuthorization(err.Error())
+				c.JSON(e.Status(), gin.H{
+					"error": e,
+				})
+				c.Abort()
+				return
+			}
+
+			if !services.TokenHasScope(accessToken, requiredScope(c)) {
+				e := apperrors.NewForbidden("token is missing the " + requiredScope(c) + " scope")
+				c.JSON(e.Status(), gin.H{
+					"error": e,
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("user", user)
+			c.Set("accessToken", accessToken)
+
+			c.Next()
 			return
 		}
 
-		userId := id.(uint)
-
-		user, err := as.GetById(userId)
+		user, err := sessionUser(c, as)
 
 		if err != nil {
 			c.JSON(401, gin.H{
@@ -41,17 +67,19 @@ func AuthUser(as services.AuthService) gin.HandlerFunc {
 
 func OptionalAuth(as services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		id := session.Get("userId")
+		if token := bearerToken(c); token != "" {
+			user, accessToken, err := as.GetByAccessToken(token)
+
+			if err == nil && services.TokenHasScope(accessToken, requiredScope(c)) {
+				c.Set("user", user)
+				c.Set("accessToken", accessToken)
+			}
 
-		if id == nil {
 			c.Next()
 			return
 		}
 
-		userId := id.(uint)
-
-		user, err := as.GetById(userId)
+		user, err := sessionUser(c, as)
 
 		if err != nil {
 			c.Next()
@@ -63,3 +91,181 @@ func OptionalAuth(as services.AuthService) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// sessionUser returns the user of the cookie session. Revoked sessions and
+// sessions created before they were tracked are cleared, so that revoking
+// all sessions of a user also covers cookies that were never tracked.
+func sessionUser(c *gin.Context, as services.AuthService) (*models.User, error) {
+	session := sessions.Default(c)
+	id := session.Get("userId")
+
+	if id == nil {
+		return nil, errors.New("provided session is invalid")
+	}
+
+	userId := id.(uint)
+	ctx := c.Request.Context()
+	sessionId, _ := session.Get("sessionId").(string)
+
+	err := errors.New("provided session is invalid")
+	if sessionId != "" {
+		err = as.TouchSession(ctx, userId, sessionId, c.ClientIP())
+	}
+
+	if err != nil {
+		session.Clear()
+		session.Options(sessions.Options{Path: "/", MaxAge: -1})
+		_ = session.Save()
+		return nil, err
+	}
+
+	user, err := as.GetById(userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set("sessionId", sessionId)
+
+	return user, nil
+}
+
+// bearerToken returns the personal access token from the Authorization header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// requiredScope returns the scope an access token needs for the request.
+// Reading only needs the read scope, everything else needs write.
+func requiredScope(c *gin.Context) string {
+	if c.Request.Method == http.MethodGet {
+		return models.ScopeRead
+	}
+	return models.ScopeWrite
+}
+
+// RequirePermission only lets users whose role has been granted the permission
+// through. It has to be used after AuthUser.
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := utils.GetUser(c)
+
+		if user == nil || !models.HasPermission(user.Role, permission) {
+			e := apperrors.NewForbidden("missing the permission to perform this action")
+			c.JSON(e.Status(), gin.H{
+				"error": e,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail only lets users that confirmed their email through.
+// It has to be used after AuthUser.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := utils.GetUser(c)
+
+		if user == nil || !user.EmailVerified {
+			e := apperrors.NewForbidden("verify your email before doing this")
+			c.JSON(e.Status(), gin.H{
+				"error": e,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimit limits the requests of the route according to the named policy.
+// Requests are counted per user if one is logged in and per IP otherwise,
+// so it has to be used after AuthUser or OptionalAuth for user based limits.
+func RateLimit(rl services.RateLimiter, policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if user := utils.GetUser(c); user != nil {
+			key = fmt.Sprintf("user:%d", user.ID)
+		}
+
+		if allowed := checkRateLimit(c, rl, policy, key); allowed {
+			c.Next()
+		}
+	}
+}
+
+// maxEmailBodyBytes is the largest body RateLimitEmail reads,
+// which is plenty for a JSON object with an email address
+const maxEmailBodyBytes = 4 << 10
+
+// RateLimitEmail limits the requests of the route per email in the JSON body,
+// so that one address cannot be flooded from many IPs
+func RateLimitEmail(rl services.RateLimiter, policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxEmailBodyBytes))
+		if err != nil {
+			e := apperrors.NewPayloadTooLarge(maxEmailBodyBytes, c.Request.ContentLength)
+			c.JSON(e.Status(), gin.H{
+				"error": e,
+			})
+			c.Abort()
+			return
+		}
+		// Leave the body for the handler to bind
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Email == "" {
+			c.Next()
+			return
+		}
+
+		key := "email:" + strings.ToLower(strings.TrimSpace(req.Email))
+		if allowed := checkRateLimit(c, rl, policy, key); allowed {
+			c.Next()
+		}
+	}
+}
+
+// checkRateLimit counts the request and sets the RateLimit headers.
+// It aborts with 429 and returns false once the limit has been reached.
+func checkRateLimit(c *gin.Context, rl services.RateLimiter, policy string, key string) bool {
+	result, err := rl.Allow(c.Request.Context(), policy, key)
+
+	// The limit is not enforced while Redis is unavailable
+	if err != nil {
+		log.Errorf("error checking rate limit: %v", err)
+		return true
+	}
+
+	if result.Limit == 0 {
+		return true
+	}
+
+	reset := int(math.Ceil(result.Reset.Seconds()))
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(reset))
+
+	if !result.Allowed {
+		e := apperrors.NewTooManyRequests(result.Reset)
+		c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+		c.JSON(e.Status(), gin.H{
+			"error": e,
+		})
+		c.Abort()
+		return false
+	}
+
+	return true
+}
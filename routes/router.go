@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sentrionic/OlympusGin/config"
 	"github.com/sentrionic/OlympusGin/controllers"
+	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/services"
 	"net/http"
 )
@@ -14,22 +15,37 @@ import (
 type Router interface {
 	gin.IRouter
 	Serve() error
-	RegisterAuthRoutes(c controllers.AuthController)
+	RegisterAuthRoutes(c controllers.AuthController, as services.AuthService)
 	RegisterUserRoutes(c controllers.UserController, as services.AuthService)
 	RegisterProfileRoutes(c controllers.ProfileController, as services.AuthService)
 	RegisterArticleRoutes(c controllers.ArticleController, as services.AuthService)
 	RegisterCommentRoutes(c controllers.CommentController, as services.AuthService)
+	RegisterRevisionRoutes(c controllers.RevisionController, as services.AuthService)
+	RegisterAdminRoutes(c controllers.AdminController, as services.AuthService)
+	RegisterTokenRoutes(c controllers.TokenController, as services.AuthService)
+	RegisterSessionRoutes(c controllers.SessionController, as services.AuthService)
+	RegisterTwoFactorRoutes(c controllers.TwoFactorController, as services.AuthService)
+	RegisterNotificationRoutes(c controllers.NotificationController, as services.AuthService)
+	RegisterEventRoutes(c controllers.EventController, as services.AuthService)
+	RegisterWebhookRoutes(c controllers.WebhookController, as services.AuthService)
+	RegisterFileRoutes(c controllers.FileController)
+	RegisterUploadRoutes(c controllers.UploadController, as services.AuthService)
 }
 
 type router struct {
 	*gin.Engine
-	c *config.Config
+	c       *config.Config
+	limiter services.RateLimiter
 }
 
-func NewRouter(c *config.Config) Router {
+func NewRouter(c *config.Config, rc database.RedisConnection) Router {
 	cfg := c.Get()
 	r := gin.New()
 
+	// X-Forwarded-For is only used for the client IP behind these proxies,
+	// otherwise anyone could pick the IP that rate limits and lockouts count
+	r.TrustedProxies = cfg.GetStringSlice("app.trustedProxies")
+
 	origin := cfg.GetString("app.origin")
 	r.Use(CORS(origin))
 
@@ -54,7 +70,7 @@ func NewRouter(c *config.Config) Router {
 
 	store.Options(sessions.Options{
 		Domain:   domain,
-		MaxAge:   1000 * 60 * 60 * 24 * 7, // 7 days
+		MaxAge:   services.SessionMaxAge,
 		Secure:   prod,
 		HttpOnly: true,
 		Path:     "/",
@@ -63,7 +79,7 @@ func NewRouter(c *config.Config) Router {
 
 	r.Use(sessions.Sessions(sessionName, store))
 
-	return &router{Engine: r, c: c}
+	return &router{Engine: r, c: c, limiter: services.NewRateLimiter(rc, c)}
 }
 
 func (r *router) Serve() error {
@@ -75,7 +91,7 @@ func CORS(origin string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Last-Event-ID")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
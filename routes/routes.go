@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/sentrionic/OlympusGin/controllers"
+	"github.com/sentrionic/OlympusGin/models"
 	"github.com/sentrionic/OlympusGin/services"
 	"github.com/sentrionic/OlympusGin/utils"
 	"net/http"
@@ -22,13 +23,16 @@ func setupDefaults(r *gin.Engine) {
 	})
 }
 
-func (r *router) RegisterAuthRoutes(c controllers.AuthController) {
+func (r *router) RegisterAuthRoutes(c controllers.AuthController, as services.AuthService) {
 	rg := r.Group("/api")
 	rg.POST("/users", c.Register)
 	rg.POST("/users/login", c.Login)
+	rg.POST("/users/login/2fa", c.LoginTwoFactor)
 	rg.POST("/users/logout", c.Logout)
-	rg.POST("/users/forgot-password", c.ForgotPassword)
+	rg.POST("/users/forgot-password", RateLimit(r.limiter, "forgotPassword"), RateLimitEmail(r.limiter, "forgotPassword"), c.ForgotPassword)
 	rg.POST("/users/reset-password", c.ResetPassword)
+	rg.POST("/users/verify-email", c.VerifyEmail)
+	rg.POST("/users/resend-verification", AuthUser(as), RateLimit(r.limiter, "resendVerification"), c.ResendVerification)
 }
 
 func (r *router) RegisterUserRoutes(c controllers.UserController, as services.AuthService) {
@@ -37,6 +41,8 @@ func (r *router) RegisterUserRoutes(c controllers.UserController, as services.Au
 	rg.Use(AuthUser(as))
 	rg.GET("/user", c.Current)
 	rg.PUT("/user", c.Edit)
+	rg.DELETE("/user", c.Delete)
+	rg.GET("/user/export", RateLimit(r.limiter, "exportData"), c.Export)
 }
 
 func (r *router) RegisterProfileRoutes(c controllers.ProfileController, as services.AuthService) {
@@ -59,11 +65,13 @@ func (r *router) RegisterArticleRoutes(c controllers.ArticleController, as servi
 	rg.GET("/articles/tags", c.GetTags)
 
 	rg.Use(AuthUser(as))
-	rg.POST("/articles", c.CreateArticle)
+	rg.POST("/articles", RateLimit(r.limiter, "createArticle"), c.CreateArticle)
 	rg.POST("/articles/:slug/favorite", c.FavoriteArticle)
 	rg.DELETE("/articles/:slug/favorite", c.UnfavoriteArticle)
 	rg.POST("/articles/:slug/bookmark", c.BookmarkArticle)
 	rg.DELETE("/articles/:slug/bookmark", c.UnbookmarkArticle)
+	rg.POST("/articles/:slug/publish", RequireVerifiedEmail(), c.PublishArticle)
+	rg.DELETE("/articles/:slug/publish", c.UnpublishArticle)
 	rg.GET("/articles/feed", c.GetFeed)
 	rg.GET("/articles/bookmarked", c.GetBookmarked)
 	rg.PUT("/articles/:slug", c.UpdateArticle)
@@ -74,8 +82,84 @@ func (r *router) RegisterCommentRoutes(c controllers.CommentController, as servi
 	rg := r.Group("/api")
 	rg.Use(OptionalAuth(as))
 	rg.GET("/articles/:slug/comments", c.GetArticleComments)
+	rg.GET("/articles/:slug/comments/:id/thread", c.GetThread)
 
 	rg.Use(AuthUser(as))
-	rg.POST("/articles/:slug/comments", c.CreateComment)
+	rg.POST("/articles/:slug/comments", RequireVerifiedEmail(), RateLimit(r.limiter, "createComment"), c.CreateComment)
+	rg.POST("/articles/:slug/comments/:id/replies", RequireVerifiedEmail(), RateLimit(r.limiter, "createComment"), c.ReplyToComment)
+	rg.PUT("/articles/:slug/comments/:id", RequireVerifiedEmail(), c.UpdateComment)
 	rg.DELETE("/articles/:slug/comments/:id", c.DeleteComment)
-}
\ No newline at end of file
+	rg.GET("/articles/:slug/comments/:id/history", c.GetCommentHistory)
+}
+
+func (r *router) RegisterRevisionRoutes(c controllers.RevisionController, as services.AuthService) {
+	rg := r.Group("/api")
+	rg.Use(AuthUser(as))
+	rg.GET("/articles/:slug/revisions", c.GetRevisions)
+	rg.GET("/articles/:slug/revisions/:version", c.GetRevision)
+	rg.POST("/articles/:slug/revisions/:version/restore", c.RestoreRevision)
+	rg.GET("/articles/:slug/diff", c.DiffRevisions)
+}
+
+func (r *router) RegisterAdminRoutes(c controllers.AdminController, as services.AuthService) {
+	rg := r.Group("/api/admin")
+	rg.Use(AuthUser(as), RequirePermission(models.PermManageUsers))
+	rg.GET("/users", c.GetUsers)
+	rg.PUT("/users/:id/role", c.UpdateUserRole)
+}
+
+func (r *router) RegisterTokenRoutes(c controllers.TokenController, as services.AuthService) {
+	rg := r.Group("/api/user")
+	rg.Use(AuthUser(as))
+	rg.GET("/tokens", c.GetTokens)
+	rg.POST("/tokens", c.CreateToken)
+	rg.DELETE("/tokens/:id", c.RevokeToken)
+}
+
+func (r *router) RegisterSessionRoutes(c controllers.SessionController, as services.AuthService) {
+	rg := r.Group("/api/user")
+	rg.Use(AuthUser(as))
+	rg.GET("/sessions", c.GetSessions)
+	rg.DELETE("/sessions", c.RevokeAllSessions)
+	rg.DELETE("/sessions/:id", c.RevokeSession)
+}
+
+func (r *router) RegisterTwoFactorRoutes(c controllers.TwoFactorController, as services.AuthService) {
+	rg := r.Group("/api/user/2fa")
+	rg.Use(AuthUser(as))
+	rg.POST("/enroll", c.Enroll)
+	rg.POST("/confirm", c.Confirm)
+	rg.DELETE("", c.Disable)
+	rg.POST("/recovery-codes", c.RegenerateRecoveryCodes)
+}
+
+func (r *router) RegisterNotificationRoutes(c controllers.NotificationController, as services.AuthService) {
+	rg := r.Group("/api/notifications")
+	rg.Use(AuthUser(as))
+	rg.GET("", c.GetNotifications)
+	rg.GET("/unread-count", c.GetUnreadCount)
+	rg.POST("/read", c.MarkAllRead)
+	rg.POST("/:id/read", c.MarkRead)
+}
+
+func (r *router) RegisterEventRoutes(c controllers.EventController, as services.AuthService) {
+	r.GET("/api/events", AuthUser(as), c.Stream)
+}
+
+func (r *router) RegisterWebhookRoutes(c controllers.WebhookController, as services.AuthService) {
+	rg := r.Group("/api/user/webhooks")
+	rg.Use(AuthUser(as))
+	rg.GET("", c.GetWebhooks)
+	rg.POST("", c.CreateWebhook)
+	rg.DELETE("/:id", c.DeleteWebhook)
+	rg.GET("/:id/deliveries", c.GetDeliveries)
+}
+
+func (r *router) RegisterFileRoutes(c controllers.FileController) {
+	r.GET("/files/*key", c.ServeFile)
+	r.HEAD("/files/*key", c.ServeFile)
+}
+
+func (r *router) RegisterUploadRoutes(c controllers.UploadController, as services.AuthService) {
+	r.POST("/api/uploads", AuthUser(as), RateLimit(r.limiter, "uploadImage"), c.CreateUpload)
+}
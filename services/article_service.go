@@ -2,13 +2,63 @@ package services
 
 import (
 	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
 	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"github.com/sentrionic/OlympusGin/utils"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"strings"
+	"time"
 )
 
+// sortOrder describes the key a list of articles is sorted by.
+// Articles sharing the same key are ordered by their id, so that
+// (key, id) uniquely identifies the position of a cursor.
+type sortOrder struct {
+	name string
+	expr string
+	vars []interface{}
+	cast string
+	desc bool
+}
+
+// publishedSort sorts articles by the time they went public, falling back
+// to the creation date for drafts that only their author can see
+func publishedSort(direction string) sortOrder {
+	return sortOrder{
+		name: direction,
+		expr: "COALESCE(articles.published_at, articles.created_at)",
+		cast: "timestamptz",
+		desc: direction != "ASC",
+	}
+}
+
+// favoritesSort sorts articles by their number of favorites
+var favoritesSort = sortOrder{
+	name: "TOP",
+	expr: "(SELECT COUNT(*) FROM article_favorites WHERE article_favorites.article_id = articles.id)",
+	cast: "bigint",
+	desc: true,
+}
+
+// relevanceSort orders search results by relevance of the weighted search_vector,
+// which the database keeps current through triggers on articles and article_tags
+func relevanceSort(search string) sortOrder {
+	return sortOrder{
+		name: "SEARCH",
+		expr: "ts_rank_cd(articles.search_vector, websearch_to_tsquery('english', ?))",
+		vars: []interface{}{search},
+		cast: "real",
+		desc: true,
+	}
+}
+
+// searchHeadline returns snippets of the article with the matches wrapped in <mark>
+const searchHeadline = `ts_headline('english', concat_ws(' ', articles.description, articles.body), websearch_to_tsquery('english', ?),
+	'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') AS headline`
+
 type ListQuery struct {
 	Limit     int
 	Page      int
@@ -18,68 +68,73 @@ type ListQuery struct {
 	Favorited string
 	Order     string
 	Search    string
+	ViewerId  uint
 }
 
 type ArticleService interface {
-	List(query ListQuery) (*[]models.Article, error)
-	Feed(userId uint, limit int, cursor string, page int) (*[]models.Article, error)
-	Bookmarked(userId uint, limit int, cursor string, page int) (*[]models.Article, error)
+	List(query ListQuery) (*[]models.Article, string, error)
+	Feed(userId uint, limit int, cursor string, page int) (*[]models.Article, string, error)
+	Bookmarked(userId uint, limit int, cursor string, page int) (*[]models.Article, string, error)
 	Create(a models.Article) (*models.Article, error)
 	GetTags() (*[]models.Tag, error)
-	GetArticleBySlug(slug string) (*models.Article, error)
-	UpdateArticle(a models.Article) error
+	GetArticleBySlug(slug string, viewerId uint) (*models.Article, error)
+	UpdateArticle(a models.Article, editorId uint) error
 	DeleteArticle(id uint) error
 	SetArticleTags(tags []string, a *models.Article) error
 	Favorite(a *models.Article, user *models.User) error
 	Unfavorite(a *models.Article, user *models.User) error
 	Bookmark(a *models.Article, user *models.User) error
 	Unbookmark(a *models.Article, user *models.User) error
+	Publish(a *models.Article) error
+	Unpublish(a *models.Article) error
+	PublishDue() ([]uint, error)
 }
 
 type articleService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	secret string
+	ns     NotificationService
+	es     EventService
+	ws     WebhookService
 }
 
-func NewArticleService(conn database.Connection) ArticleService {
-	return &articleService{db: conn.Get()}
-}
-
-func (as *articleService) List(lq ListQuery) (*[]models.Article, error) {
-	var a []models.Article
-	offset := 0
-	if lq.Page > 0 {
-		offset = lq.Page - 1
+func NewArticleService(
+	conn database.Connection,
+	c *config.Config,
+	ns NotificationService,
+	es EventService,
+	ws WebhookService,
+) ArticleService {
+	return &articleService{
+		db:     conn.Get(),
+		secret: c.Get().GetString("app.secret"),
+		ns:     ns,
+		es:     es,
+		ws:     ws,
 	}
+}
 
+func (as *articleService) List(lq ListQuery) (*[]models.Article, string, error) {
 	query := as.db.
 		Preload("Author.Followee").
 		Preload("Author.Followers").
 		Preload(clause.Associations)
 
-	if lq.Order == "TOP" {
-		query.Select(`
-			"articles"."id",
-			"articles"."created_at",
-			"articles"."updated_at",       
-			"articles"."slug",
-			"articles"."title",
-			"articles"."description",
-			"articles"."body",
-			"articles"."image",
-			"articles"."author_id",
-			(select count(*) from article_favorites where article_favorites.article_id = articles.id) as count
-		`)
-		query.Order("count DESC")
-	} else {
-		query.Order(fmt.Sprintf("created_at %s", lq.Order))
-	}
+	selects := []string{`"articles".*`}
+	var args []interface{}
 
-	if lq.Cursor != "" {
-		cursor := lq.Cursor[:len(lq.Cursor)-6]
-		query.
-			Where("created_at < ?", cursor)
+	order := publishedSort(lq.Order)
+	if lq.Search != "" {
+		order = relevanceSort(lq.Search)
+		selects = append(selects, searchHeadline)
+		args = append(args, lq.Search)
+		query.Where("articles.search_vector @@ websearch_to_tsquery('english', ?)", lq.Search)
+	} else if lq.Order == "TOP" {
+		order = favoritesSort
 	}
 
+	query.Where("articles.status = ? OR articles.author_id = ?", models.StatusPublished, lq.ViewerId)
+
 	if lq.Tag != "" {
 		var t []models.Tag
 		search := "%" + strings.ToLower(lq.Tag) + "%"
@@ -90,8 +145,7 @@ func (as *articleService) List(lq ListQuery) (*[]models.Article, error) {
 			ids = append(ids, tag.ID)
 		}
 
-		query.Joins("JOIN article_tags ON article_tags.article_id = \"articles\".id").
-			Where("article_tags.tag_id IN ?", ids)
+		query.Where("articles.id IN (SELECT article_id FROM article_tags WHERE tag_id IN ?)", ids)
 	}
 
 	if lq.Author != "" {
@@ -109,80 +163,100 @@ func (as *articleService) List(lq ListQuery) (*[]models.Article, error) {
 			Where("article_favorites.user_id = ?", u.ID)
 	}
 
-	if lq.Search != "" {
-		search := "%" + strings.ToLower(lq.Search) + "%"
-		query.Where("LOWER(title) LIKE ? or LOWER(description) LIKE ?", search, search)
-	}
-
-	query.Limit(lq.Limit).
-		Offset(offset * (lq.Limit - 1)).
-		Find(&a)
-
-	return &a, query.Error
+	return as.paginate(query, selects, args, order, lq.Cursor, lq.Limit, lq.Page)
 }
 
-func (as *articleService) Feed(userId uint, limit int, cursor string, page int) (*[]models.Article, error) {
-	var a []models.Article
-	offset := 0
-	if page > 0 {
-		offset = page - 1
-	}
-
+func (as *articleService) Feed(userId uint, limit int, cursor string, page int) (*[]models.Article, string, error) {
 	query := as.db.
 		Preload("Author.Followee").
 		Preload("Author.Followers").
 		Preload(clause.Associations).
 		Joins("LEFT JOIN users u ON u.id = \"articles\".author_id").
 		Joins("LEFT JOIN followee on u.id = followee.followee_id").
-		Where("followee.user_id = ?", userId)
-
-	if cursor != "" {
-		cursor = cursor[:len(cursor)-6]
-		query.
-			Where("created_at < ?", cursor)
-	}
+		Where("followee.user_id = ?", userId).
+		Where("articles.status = ?", models.StatusPublished)
 
-	query.
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset * (limit - 1)).
-		Find(&a)
-
-	return &a, query.Error
+	return as.paginate(query, []string{`"articles".*`}, nil, publishedSort("DESC"), cursor, limit, page)
 }
 
-func (as *articleService) Bookmarked(userId uint, limit int, cursor string, page int) (*[]models.Article, error) {
-	var a []models.Article
-	offset := 0
-	if page > 0 {
-		offset = page - 1
-	}
-
+func (as *articleService) Bookmarked(userId uint, limit int, cursor string, page int) (*[]models.Article, string, error) {
 	query := as.db.
 		Preload("Author.Followee").
 		Preload("Author.Followers").
 		Preload(clause.Associations).
 		Joins("JOIN article_bookmarks ON article_bookmarks.article_id = \"articles\".id").
-		Where("article_bookmarks.user_id = ?", userId)
+		Where("article_bookmarks.user_id = ?", userId).
+		Where("articles.status <> ? OR articles.author_id = ?", models.StatusDraft, userId)
+
+	return as.paginate(query, []string{`"articles".*`}, nil, publishedSort("DESC"), cursor, limit, page)
+}
+
+// paginate sorts the query by the given order and returns the page after the
+// cursor, or the given page number when there is no cursor, together with the
+// cursor of the next page. The next cursor is empty on the last page.
+func (as *articleService) paginate(query *gorm.DB, selects []string, args []interface{}, order sortOrder, cursor string, limit int, page int) (*[]models.Article, string, error) {
+	var a []models.Article
+
+	direction, comparison := "ASC", ">"
+	if order.desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	selects = append(selects, fmt.Sprintf("(%s)::text AS sort_key", order.expr))
+	args = append(args, order.vars...)
+	query.Select(strings.Join(selects, ", "), args...)
+
+	query.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:  fmt.Sprintf("%s %s, articles.id %s", order.expr, direction, direction),
+		Vars: order.vars,
+	}})
 
 	if cursor != "" {
-		cursor = cursor[:len(cursor)-6]
-		query.
-			Where("created_at < ?", cursor)
+		c, err := utils.DecodeCursor(as.secret, cursor)
+		if err != nil || c.Order != order.name {
+			return nil, "", apperrors.NewBadRequest(utils.ErrInvalidCursor.Error())
+		}
+
+		vars := append(append([]interface{}{}, order.vars...), c.Key, c.Id)
+		query.Where(fmt.Sprintf("(%s, articles.id) %s (CAST(? AS %s), ?)", order.expr, comparison, order.cast), vars...)
+	} else if page > 1 {
+		query.Offset((page - 1) * limit)
 	}
 
-	query.
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset * (limit - 1)).
-		Find(&a)
+	if err := query.Limit(limit + 1).Find(&a).Error; err != nil {
+		return nil, "", err
+	}
+
+	if len(a) <= limit {
+		return &a, "", nil
+	}
+
+	a = a[:limit]
+	last := a[limit-1]
+	next, err := utils.EncodeCursor(as.secret, utils.Cursor{
+		Order: order.name,
+		Key:   last.SortKey,
+		Id:    last.ID,
+	})
 
-	return &a, query.Error
+	return &a, next, err
 }
 
 func (as *articleService) Create(a models.Article) (*models.Article, error) {
-	result := as.db.Create(&a)
-	return &a, result.Error
+	err := as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+		if err := linkUploads(tx, a.ID, a.Body); err != nil {
+			return err
+		}
+		return createRevision(tx, &a, a.AuthorId)
+	})
+
+	if err == nil && a.Status != models.StatusDraft && a.Status != models.StatusUnlisted {
+		as.announce(&a)
+	}
+	return &a, err
 }
 
 func (as *articleService) GetTags() (*[]models.Tag, error) {
@@ -194,28 +268,106 @@ func (as *articleService) GetTags() (*[]models.Tag, error) {
 	return &t, result.Error
 }
 
-func (as *articleService) GetArticleBySlug(slug string) (*models.Article, error) {
+// GetArticleBySlug returns the article unless it is a draft that does not
+// belong to the viewer, in which case an empty article is returned.
+func (as *articleService) GetArticleBySlug(slug string, viewerId uint) (*models.Article, error) {
 	var a models.Article
 	result := as.db.
 		Preload("Author.Followee").
 		Preload("Author.Followers").
 		Preload(clause.Associations).
 		Where("slug = ?", slug).
+		Where("status <> ? OR author_id = ?", models.StatusDraft, viewerId).
 		FirstOrInit(&a)
 
 	return &a, result.Error
 }
 
-func (as *articleService) UpdateArticle(a models.Article) error {
-	result := as.db.Save(&a)
-	return result.Error
+// UpdateArticle saves the article and records its new content as a revision.
+// Articles written before revisions existed get their previous content stored
+// as the first revision, so the first edit can still be undone.
+func (as *articleService) UpdateArticle(a models.Article, editorId uint) error {
+	var previousStatus string
+	err := as.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent updates of the article wait here, so that they never pick the same revision number
+		err := tx.Model(&models.Article{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("status").
+			Where("id = ?", a.ID).
+			Scan(&previousStatus).
+			Error
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.ArticleRevision{}).Where("article_id = ?", a.ID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count == 0 {
+			var previous models.Article
+			if err := tx.Preload("Tags").First(&previous, a.ID).Error; err != nil {
+				return err
+			}
+			if err := createRevision(tx, &previous, previous.AuthorId); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Save(&a).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&a).Association("Tags").Replace(a.Tags); err != nil {
+			return err
+		}
+
+		if err := linkUploads(tx, a.ID, a.Body); err != nil {
+			return err
+		}
+
+		return createRevision(tx, &a, editorId)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if a.Status == models.StatusPublished && previousStatus != models.StatusPublished {
+		as.announce(&a)
+	} else {
+		// Drafts and unlisted articles are only sent to the webhooks of the author
+		emitWebhook(as.ws, models.WebhookArticleUpdated, a.AuthorId, a.Status == models.StatusPublished, articleWebhookData(&a))
+	}
+	return nil
 }
 
 func (as *articleService) DeleteArticle(id uint) error {
-	err := as.db.Exec("DELETE FROM article_tags where article_id = ?", id).
+	var a models.Article
+	if err := as.db.First(&a, id).Error; err != nil {
+		return err
+	}
+
+	if err := deleteArticle(as.db, id); err != nil {
+		return err
+	}
+
+	emitWebhook(as.ws, models.WebhookArticleDeleted, a.AuthorId, a.Status == models.StatusPublished, articleWebhookData(&a))
+	return nil
+}
+
+// deleteArticle removes the article together with everything that references it
+func deleteArticle(tx *gorm.DB, id uint) error {
+	err := tx.Exec("DELETE FROM article_tags where article_id = ?", id).
 		Exec("DELETE FROM article_favorites where article_id = ?", id).
 		Exec("DELETE FROM article_bookmarks where article_id = ?", id).
+		Exec("DELETE FROM comment_edits where comment_id IN (SELECT id FROM comments where article_id = ?)", id).
 		Exec("DELETE FROM comments where article_id = ?", id).
+		Exec("DELETE FROM article_revisions where article_id = ?", id).
+		Exec("DELETE FROM article_uploads where article_id = ?", id).
+		Exec("DELETE FROM notification_actors where notification_id IN (SELECT id FROM notifications where article_id = ?)", id).
+		Exec("DELETE FROM notifications where article_id = ?", id).
 		Delete(&models.Article{}, id)
 	return err.Error
 }
@@ -242,6 +394,10 @@ func (as *articleService) Favorite(article *models.Article, current *models.User
 			"user_id":    current.ID,
 			"article_id": article.ID,
 		}).Error
+
+	if err == nil {
+		notify(as.ns, article.AuthorId, models.NotificationFavorite, &article.ID, current.ID)
+	}
 	return err
 }
 
@@ -267,3 +423,98 @@ func (as *articleService) Unbookmark(article *models.Article, current *models.Us
 		Error
 	return err
 }
+
+func (as *articleService) Publish(article *models.Article) error {
+	now := time.Now()
+	article.Status = models.StatusPublished
+	article.PublishAt = nil
+	article.PublishedAt = &now
+
+	err := as.db.Model(article).Updates(map[string]interface{}{
+		"status":       article.Status,
+		"publish_at":   nil,
+		"published_at": article.PublishedAt,
+	}).Error
+
+	if err == nil {
+		as.announce(article)
+	}
+	return err
+}
+
+func (as *articleService) Unpublish(article *models.Article) error {
+	article.Status = models.StatusDraft
+	article.PublishAt = nil
+	article.PublishedAt = nil
+
+	err := as.db.Model(article).Updates(map[string]interface{}{
+		"status":       article.Status,
+		"publish_at":   nil,
+		"published_at": nil,
+	}).Error
+
+	if err == nil {
+		emitWebhook(as.ws, models.WebhookArticleUpdated, article.AuthorId, false, articleWebhookData(article))
+	}
+	return err
+}
+
+// PublishDue publishes every draft whose publishAt time has passed and returns
+// their ids. The rows are locked with SKIP LOCKED so that several replicas
+// running the scheduler at the same time never publish the same article twice.
+func (as *articleService) PublishDue() ([]uint, error) {
+	var ids []uint
+	err := as.db.Raw(`
+		WITH due AS (
+			SELECT id FROM articles
+			WHERE status = ? AND publish_at <= NOW()
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE articles
+		SET status = ?, published_at = articles.publish_at, publish_at = NULL, updated_at = NOW()
+		FROM due
+		WHERE articles.id = due.id
+		RETURNING articles.id
+	`, models.StatusDraft, models.StatusPublished).Scan(&ids).Error
+
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	var published []models.Article
+	if err := as.db.Find(&published, ids).Error; err != nil {
+		return ids, err
+	}
+
+	for i := range published {
+		as.announce(&published[i])
+	}
+
+	return ids, nil
+}
+
+// announce tells the followers of the author and the webhooks about a newly published article
+func (as *articleService) announce(a *models.Article) {
+	publishEvent(as.es, FeedTopic(a.AuthorId), models.EventFeed, map[string]interface{}{
+		"id":       a.ID,
+		"slug":     a.Slug,
+		"title":    a.Title,
+		"authorId": a.AuthorId,
+	})
+
+	emitWebhook(as.ws, models.WebhookArticlePublished, a.AuthorId, true, articleWebhookData(a))
+}
+
+// articleWebhookData is the article as it is sent to webhooks
+func articleWebhookData(a *models.Article) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          a.ID,
+		"slug":        a.Slug,
+		"title":       a.Title,
+		"description": a.Description,
+		"status":      a.Status,
+		"authorId":    a.AuthorId,
+		"publishedAt": a.PublishedAt,
+		"updatedAt":   a.UpdatedAt,
+	}
+}
@@ -1,34 +1,72 @@
 package services
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	"github.com/sentrionic/OlympusGin/utils"
 	"gorm.io/gorm"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type AuthService interface {
 	Register(u models.User) (*models.User, error)
-	Login(email string, password string) (*models.User, error)
+	Login(ctx context.Context, email string, password string, ip string) (*models.User, error)
+	LoginTwoFactor(ctx context.Context, challenge string, code string, ip string) (*models.User, error)
 	ChangePassword(u models.User, password string) error
+	VerifyEmail(id uint, email string) error
 	GetById(id uint) (*models.User, error)
 	GetByEmail(email string) (*models.User, error)
 	GetByUsername(username string) (*models.User, error)
+	GetByAccessToken(token string) (*models.User, *models.AccessToken, error)
+	CreateSession(ctx context.Context, userId uint, ip string, userAgent string) (string, error)
+	TouchSession(ctx context.Context, userId uint, sessionId string, ip string) error
+	GetSessions(ctx context.Context, userId uint) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userId uint, sessionId string) error
+	RevokeSessions(ctx context.Context, userId uint, except string) error
 }
 
+// SessionMaxAge is how long a login session stays valid in seconds
+const SessionMaxAge = 60 * 60 * 24 * 7
+
+// Login throttling. Failures are counted per email and per IP within loginFailureWindow.
+// After the free attempts every failure blocks further attempts with an exponential
+// backoff and reaching the lockout threshold blocks them for loginLockoutDuration.
+const (
+	loginFailureWindow        = time.Hour
+	loginFreeAttempts         = 3
+	loginMaxBackoff           = 5 * time.Minute
+	loginLockoutDuration      = 15 * time.Minute
+	loginEmailLockoutAfter    = 10
+	loginIPLockoutAfter       = 50
+	loginIncorrectCredentials = "incorrect credentials"
+)
+
+var errSessionRevoked = errors.New("provided session is invalid")
+
 type authService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	redis *redis.Client
+	mail  MailService
+	tfs   TwoFactorService
 }
 
-func NewAuthService(conn database.Connection) AuthService {
+func NewAuthService(conn database.Connection, rc database.RedisConnection, ms MailService, tfs TwoFactorService) AuthService {
 	return &authService{
-		db: conn.Get(),
+		db:    conn.Get(),
+		redis: rc.Get(),
+		mail:  ms,
+		tfs:   tfs,
 	}
 }
 
@@ -46,18 +84,162 @@ func (as *authService) Register(u models.User) (*models.User, error) {
 	return &u, result.Error
 }
 
-func (as *authService) Login(email string, password string) (*models.User, error) {
+// Login checks the credentials unless the email or the IP is currently blocked
+// because of previous failures, in which case a TooManyRequests error is returned
+func (as *authService) Login(ctx context.Context, email string, password string, ip string) (*models.User, error) {
+	if err := as.checkLoginBlocked(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
 	var result models.User
 	if err := as.db.Where("email = ?", email).First(&result); err.Error != nil {
-		return nil, errors.New("incorrect credentials")
+		as.recordLoginFailure(ctx, nil, email, ip)
+		return nil, apperrors.NewAuthorization(loginIncorrectCredentials)
 	}
 
 	if !utils.CheckPassword(password, result.PasswordHash) {
-		return nil, errors.New("incorrect credentials")
+		as.recordLoginFailure(ctx, &result, email, ip)
+		return nil, apperrors.NewAuthorization(loginIncorrectCredentials)
+	}
+
+	// With two-factor authentication the login only succeeds once the code has been entered
+	if !result.TOTPEnabled {
+		as.clearLoginFailures(ctx, email)
 	}
+
+	// Upgrade bcrypt hashes and hashes with outdated parameters while the password is known
+	if utils.NeedsRehash(result.PasswordHash) {
+		as.rehashPassword(&result, password)
+	}
+
 	return &result, nil
 }
 
+// LoginTwoFactor finishes the login of a user with two-factor authentication.
+// Invalid codes count as failed logins of the user, so that the code cannot
+// be guessed by requesting new challenges with the password.
+func (as *authService) LoginTwoFactor(ctx context.Context, challenge string, code string, ip string) (*models.User, error) {
+	user, err := as.tfs.GetChallenge(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.checkLoginBlocked(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+
+	if err := as.tfs.Verify(ctx, user, code); err != nil {
+		var e *apperrors.Error
+		if errors.As(err, &e) && e.Type == apperrors.Authorization {
+			as.recordLoginFailure(ctx, user, user.Email, ip)
+		}
+		return nil, err
+	}
+
+	as.tfs.DeleteChallenge(ctx, challenge)
+	as.clearLoginFailures(ctx, user.Email)
+
+	return user, nil
+}
+
+func (as *authService) clearLoginFailures(ctx context.Context, email string) {
+	as.redis.Del(ctx, loginFailuresKey("email", email), loginBlockKey("email", email))
+}
+
+func (as *authService) rehashPassword(u *models.User, password string) {
+	hash, err := utils.HashPassword(password)
+
+	if err != nil {
+		fmt.Printf("error rehashing password: %v", err)
+		return
+	}
+
+	err = as.db.Model(&models.User{}).Where("id = ?", u.ID).UpdateColumn("password", hash).Error
+
+	if err != nil {
+		fmt.Printf("error rehashing password: %v", err)
+		return
+	}
+
+	u.PasswordHash = hash
+}
+
+func (as *authService) checkLoginBlocked(ctx context.Context, email string, ip string) error {
+	for _, key := range []string{loginBlockKey("email", email), loginBlockKey("ip", ip)} {
+		ttl, err := as.redis.PTTL(ctx, key).Result()
+
+		if err != nil {
+			return err
+		}
+
+		if ttl > 0 {
+			return apperrors.NewTooManyRequests(ttl)
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts the failure and blocks the email and the IP once they
+// used up their free attempts. The owner of the email is notified when a lockout starts,
+// without making the failed login wait for the mail server.
+func (as *authService) recordLoginFailure(ctx context.Context, user *models.User, email string, ip string) {
+	if as.blockAfterFailure(ctx, "email", email, loginEmailLockoutAfter) && user != nil {
+		go as.mail.SendLockoutEmail(LockoutInput{
+			Email:    user.Email,
+			IP:       ip,
+			Duration: loginLockoutDuration,
+		})
+	}
+
+	as.blockAfterFailure(ctx, "ip", ip, loginIPLockoutAfter)
+}
+
+// blockAfterFailure increments the failures of the key and sets the block.
+// It returns true if the failure started a lockout, concurrent failures
+// that exceed the threshold as well only extend it.
+func (as *authService) blockAfterFailure(ctx context.Context, kind string, value string, lockoutAfter int64) bool {
+	var failures *redis.IntCmd
+	_, err := as.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, loginFailuresKey(kind, value))
+		pipe.Expire(ctx, loginFailuresKey(kind, value), loginFailureWindow)
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("error counting login failure: %v", err)
+		return false
+	}
+
+	count := failures.Val()
+	if count <= loginFreeAttempts {
+		return false
+	}
+
+	locked := count >= lockoutAfter
+	duration := loginLockoutDuration
+
+	if !locked {
+		duration = loginMaxBackoff
+		if shift := count - loginFreeAttempts - 1; shift < 16 {
+			if backoff := time.Second << shift; backoff < duration {
+				duration = backoff
+			}
+		}
+	}
+
+	if err := as.redis.Set(ctx, loginBlockKey(kind, value), 1, duration).Err(); err != nil {
+		fmt.Printf("error blocking login: %v", err)
+		return false
+	}
+
+	// The failures are reset with the lockout, so the next attempts start with a short backoff again
+	if locked {
+		as.redis.Del(ctx, loginFailuresKey(kind, value))
+	}
+
+	return count == lockoutAfter
+}
+
 func (as *authService) GetById(id uint) (*models.User, error) {
 	var u models.User
 	result := as.db.Where("id = ?", id).First(&u)
@@ -94,6 +276,179 @@ func (as *authService) ChangePassword(u models.User, password string) error {
 	return nil
 }
 
+// VerifyEmail sets the email of the user and marks it as verified
+func (as *authService) VerifyEmail(id uint, email string) error {
+	result := as.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":          email,
+		"email_verified": true,
+		"updated_at":     time.Now(),
+	})
+	return result.Error
+}
+
+// GetByAccessToken returns the owner of a personal access token
+// if the token exists and has not expired
+func (as *authService) GetByAccessToken(token string) (*models.User, *models.AccessToken, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, nil, errInvalidToken
+	}
+
+	var t models.AccessToken
+	result := as.db.
+		Preload("User").
+		Where("token_hash = ?", utils.HashToken(token)).
+		First(&t)
+
+	if result.Error != nil {
+		return nil, nil, errInvalidToken
+	}
+
+	now := time.Now()
+	if t.ExpiresAt != nil && t.ExpiresAt.Before(now) {
+		return nil, nil, errInvalidToken
+	}
+
+	as.db.Model(&t).UpdateColumn("last_used_at", now)
+
+	return &t.User, &t, nil
+}
+
+// CreateSession starts tracking a new login session of the user and returns its id.
+// The metadata is stored in the hash session:{id} and the ids of all sessions
+// of a user are kept in the set user-sessions:{userId}.
+func (as *authService) CreateSession(ctx context.Context, userId uint, ip string, userAgent string) (string, error) {
+	uid, err := uuid.NewRandom()
+
+	if err != nil {
+		return "", err
+	}
+
+	id := uid.String()
+	now := time.Now().Unix()
+
+	_, err = as.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, sessionKey(id), map[string]interface{}{
+			"userId":     userId,
+			"createdAt":  now,
+			"lastSeenAt": now,
+			"ip":         ip,
+			"userAgent":  userAgent,
+		})
+		pipe.Expire(ctx, sessionKey(id), SessionMaxAge*time.Second)
+		pipe.SAdd(ctx, userSessionsKey(userId), id)
+		pipe.Expire(ctx, userSessionsKey(userId), SessionMaxAge*time.Second)
+		return nil
+	})
+
+	return id, err
+}
+
+// TouchSession records activity on the session and
+// returns an error if the session has been revoked
+func (as *authService) TouchSession(ctx context.Context, userId uint, sessionId string, ip string) error {
+	owner, err := as.redis.HGet(ctx, sessionKey(sessionId), "userId").Result()
+
+	if err != nil || owner != strconv.Itoa(int(userId)) {
+		return errSessionRevoked
+	}
+
+	return as.redis.HSet(ctx, sessionKey(sessionId), "lastSeenAt", time.Now().Unix(), "ip", ip).Err()
+}
+
+// GetSessions returns all active sessions of the user, most recently used first
+func (as *authService) GetSessions(ctx context.Context, userId uint) ([]models.Session, error) {
+	ids, err := as.redis.SMembers(ctx, userSessionsKey(userId)).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0)
+	for _, id := range ids {
+		values, err := as.redis.HGetAll(ctx, sessionKey(id)).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The session expired, so it only has to be removed from the set
+		if len(values) == 0 {
+			as.redis.SRem(ctx, userSessionsKey(userId), id)
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(values["createdAt"], 10, 64)
+		lastSeenAt, _ := strconv.ParseInt(values["lastSeenAt"], 10, 64)
+
+		sessions = append(sessions, models.Session{
+			ID:         id,
+			CreatedAt:  time.Unix(createdAt, 0),
+			LastSeenAt: time.Unix(lastSeenAt, 0),
+			IP:         values["ip"],
+			UserAgent:  values["userAgent"],
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (as *authService) RevokeSession(ctx context.Context, userId uint, sessionId string) error {
+	removed, err := as.redis.SRem(ctx, userSessionsKey(userId), sessionId).Result()
+
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		return apperrors.NewNotFound("session", sessionId)
+	}
+
+	return as.redis.Del(ctx, sessionKey(sessionId)).Err()
+}
+
+// RevokeSessions revokes every session of the user except the given one,
+// which may be empty to revoke all of them
+func (as *authService) RevokeSessions(ctx context.Context, userId uint, except string) error {
+	ids, err := as.redis.SMembers(ctx, userSessionsKey(userId)).Result()
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id == except {
+			continue
+		}
+
+		if err := as.redis.Del(ctx, sessionKey(id)).Err(); err != nil {
+			return err
+		}
+		as.redis.SRem(ctx, userSessionsKey(userId), id)
+	}
+
+	return nil
+}
+
+func loginFailuresKey(kind string, value string) string {
+	return fmt.Sprintf("login-failures:%s:%s", kind, value)
+}
+
+func loginBlockKey(kind string, value string) string {
+	return fmt.Sprintf("login-block:%s:%s", kind, value)
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session:%s", id)
+}
+
+func userSessionsKey(userId uint) string {
+	return fmt.Sprintf("user-sessions:%d", userId)
+}
+
 func getMD5Hash(email string) string {
 	hash := md5.Sum([]byte(email))
 	return hex.EncodeToString(hash[:])
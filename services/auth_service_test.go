@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+)
+
+const validCode = "123456"
+
+// fakeTwoFactorService resolves every known challenge to the same user
+type fakeTwoFactorService struct {
+	TwoFactorService
+	user       *models.User
+	challenges map[string]bool
+	verifyErr  error
+}
+
+func (f *fakeTwoFactorService) GetChallenge(_ context.Context, challenge string) (*models.User, error) {
+	if !f.challenges[challenge] {
+		return nil, apperrors.NewAuthorization("invalid or expired challenge")
+	}
+	return f.user, nil
+}
+
+func (f *fakeTwoFactorService) DeleteChallenge(_ context.Context, challenge string) {
+	delete(f.challenges, challenge)
+}
+
+func (f *fakeTwoFactorService) Verify(_ context.Context, _ *models.User, code string) error {
+	if f.verifyErr != nil {
+		return f.verifyErr
+	}
+	if code != validCode {
+		return apperrors.NewAuthorization(errInvalidSecondFactor)
+	}
+	return nil
+}
+
+type fakeMailService struct {
+	MailService
+	lockouts chan LockoutInput
+}
+
+func (f *fakeMailService) SendLockoutEmail(in LockoutInput) {
+	f.lockouts <- in
+}
+
+func newTestAuthService(t *testing.T) (*authService, *fakeTwoFactorService, *fakeMailService, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	tfs := &fakeTwoFactorService{
+		user:       &models.User{Email: "user@example.com", TOTPEnabled: true},
+		challenges: make(map[string]bool),
+	}
+	ms := &fakeMailService{lockouts: make(chan LockoutInput, 10)}
+
+	as := &authService{
+		redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		mail:  ms,
+		tfs:   tfs,
+	}
+
+	return as, tfs, ms, mr
+}
+
+func errorType(err error) apperrors.Type {
+	var e *apperrors.Error
+	if errors.As(err, &e) {
+		return e.Type
+	}
+	return ""
+}
+
+func TestLoginTwoFactorSucceeds(t *testing.T) {
+	as, tfs, _, mr := newTestAuthService(t)
+	ctx := context.Background()
+	tfs.challenges["challenge"] = true
+
+	if _, err := as.LoginTwoFactor(ctx, "challenge", "000000", "127.0.0.1"); errorType(err) != apperrors.Authorization {
+		t.Fatalf("got %v for an invalid code, want an authorization error", err)
+	}
+
+	user, err := as.LoginTwoFactor(ctx, "challenge", validCode, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("got %v for a valid code", err)
+	}
+	if user != tfs.user {
+		t.Errorf("got user %v, want %v", user, tfs.user)
+	}
+
+	if tfs.challenges["challenge"] {
+		t.Error("the challenge was not deleted after the login")
+	}
+	if mr.Exists(loginFailuresKey("email", tfs.user.Email)) {
+		t.Error("the failures of the email were not cleared after the login")
+	}
+	if !mr.Exists(loginFailuresKey("ip", "127.0.0.1")) {
+		t.Error("the failures of the IP were cleared after the login")
+	}
+}
+
+func TestLoginTwoFactorCountsFailuresAcrossChallenges(t *testing.T) {
+	as, tfs, ms, mr := newTestAuthService(t)
+	ctx := context.Background()
+
+	// Every attempt uses a new challenge, as an attacker who knows the password could
+	for i := 1; i <= loginEmailLockoutAfter; i++ {
+		tfs.challenges["challenge"] = true
+
+		_, err := as.LoginTwoFactor(ctx, "challenge", "000000", "127.0.0.1")
+		if errorType(err) != apperrors.Authorization {
+			t.Fatalf("attempt %d: got %v, want an authorization error", i, err)
+		}
+
+		if i > loginFreeAttempts && i < loginEmailLockoutAfter {
+			if _, err := as.LoginTwoFactor(ctx, "challenge", validCode, "127.0.0.1"); errorType(err) != apperrors.TooManyRequests {
+				t.Fatalf("attempt %d: got %v during the backoff, want a too many requests error", i, err)
+			}
+			mr.FastForward(loginMaxBackoff)
+		}
+	}
+
+	if ttl := mr.TTL(loginBlockKey("email", tfs.user.Email)); ttl != loginLockoutDuration {
+		t.Errorf("got a block of %v, want the lockout of %v", ttl, loginLockoutDuration)
+	}
+
+	if _, err := as.LoginTwoFactor(ctx, "challenge", validCode, "10.0.0.1"); errorType(err) != apperrors.TooManyRequests {
+		t.Errorf("got %v during the lockout, want a too many requests error", err)
+	}
+
+	select {
+	case in := <-ms.lockouts:
+		if in.Email != tfs.user.Email {
+			t.Errorf("got the lockout email for %s, want %s", in.Email, tfs.user.Email)
+		}
+	case <-time.After(time.Second):
+		t.Error("no lockout email was sent")
+	}
+}
+
+func TestRecordLoginFailureNotifiesOncePerLockout(t *testing.T) {
+	as, tfs, ms, mr := newTestAuthService(t)
+	ctx := context.Background()
+	key := loginFailuresKey("email", tfs.user.Email)
+
+	mr.Set(key, strconv.Itoa(loginEmailLockoutAfter-1))
+	as.recordLoginFailure(ctx, tfs.user, tfs.user.Email, "127.0.0.1")
+
+	select {
+	case <-ms.lockouts:
+	case <-time.After(time.Second):
+		t.Fatal("no lockout email was sent")
+	}
+
+	// A request that passed the block check at the same time fails past the threshold
+	mr.Set(key, strconv.Itoa(loginEmailLockoutAfter))
+	as.recordLoginFailure(ctx, tfs.user, tfs.user.Email, "127.0.0.1")
+
+	if ttl := mr.TTL(loginBlockKey("email", tfs.user.Email)); ttl != loginLockoutDuration {
+		t.Errorf("got a block of %v, want the lockout of %v", ttl, loginLockoutDuration)
+	}
+
+	select {
+	case <-ms.lockouts:
+		t.Error("the lockout email was sent more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLoginTwoFactorIgnoresInternalErrors(t *testing.T) {
+	as, tfs, _, mr := newTestAuthService(t)
+	ctx := context.Background()
+	tfs.challenges["challenge"] = true
+	tfs.verifyErr = errors.New("connection refused")
+
+	if _, err := as.LoginTwoFactor(ctx, "challenge", validCode, "127.0.0.1"); err != tfs.verifyErr {
+		t.Fatalf("got %v, want %v", err, tfs.verifyErr)
+	}
+
+	if mr.Exists(loginFailuresKey("email", tfs.user.Email)) {
+		t.Error("an internal error was counted as a failed login")
+	}
+}
+
+func TestLoginTwoFactorInvalidChallenge(t *testing.T) {
+	as, _, _, mr := newTestAuthService(t)
+
+	if _, err := as.LoginTwoFactor(context.Background(), "unknown", validCode, "127.0.0.1"); errorType(err) != apperrors.Authorization {
+		t.Fatalf("got %v, want an authorization error", err)
+	}
+
+	if len(mr.Keys()) != 0 {
+		t.Errorf("got keys %v, want none", mr.Keys())
+	}
+}
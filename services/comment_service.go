@@ -1,46 +1,161 @@
 package services
 
 import (
+	"github.com/sentrionic/OlympusGin/config"
 	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"github.com/sentrionic/OlympusGin/utils"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"time"
 )
 
 type CommentService interface {
 	Create(comment models.Comment) (*models.Comment, error)
-	List(slug string) (*[]models.Comment, error)
+	All(articleId uint) (*[]models.Comment, error)
+	List(articleId uint, limit int, cursor string) (*[]models.Comment, string, error)
+	ListRoots(articleId uint, limit int, cursor string) (*[]models.Comment, string, error)
+	Thread(rootIds []uint) (*[]models.Comment, error)
 	Delete(comment models.Comment) error
 	Get(id uint) (*models.Comment, error)
+	Update(comment *models.Comment, body string, editorId uint) error
+	History(commentId uint) (*[]models.CommentEdit, error)
 }
 
 type commentService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	secret string
+	ns     NotificationService
+	ws     WebhookService
 }
 
-func NewCommentService(conn database.Connection) CommentService {
-	return &commentService{db: conn.Get()}
+func NewCommentService(conn database.Connection, c *config.Config, ns NotificationService, ws WebhookService) CommentService {
+	return &commentService{
+		db:     conn.Get(),
+		secret: c.Get().GetString("app.secret"),
+		ns:     ns,
+		ws:     ws,
+	}
 }
 
 func (cs *commentService) Create(comment models.Comment) (*models.Comment, error) {
 	result := cs.db.Create(&comment)
+
+	if result.Error == nil {
+		var article models.Article
+		if err := cs.db.Select("id", "slug", "author_id", "status").First(&article, comment.ArticleID).Error; err == nil {
+			notify(cs.ns, article.AuthorId, models.NotificationComment, &article.ID, comment.AuthorID)
+			emitWebhook(cs.ws, models.WebhookCommentCreated, article.AuthorId, article.Status == models.StatusPublished, map[string]interface{}{
+				"id":          comment.ID,
+				"articleId":   article.ID,
+				"articleSlug": article.Slug,
+				"parentId":    comment.ParentID,
+				"authorId":    comment.AuthorID,
+				"body":        comment.Body,
+				"createdAt":   comment.CreatedAt,
+			})
+		}
+	}
+
 	return &comment, result.Error
 }
 
-func (cs *commentService) List(slug string) (*[]models.Comment, error) {
+// All returns every comment of the article in the order they were written
+func (cs *commentService) All(articleId uint) (*[]models.Comment, error) {
+	var c []models.Comment
+	result := cs.db.
+		Preload(clause.Associations).
+		Where("article_id = ?", articleId).
+		Order("comments.id ASC").
+		Find(&c)
+
+	return &c, result.Error
+}
+
+// List returns a page of all comments of the article in the order they were written
+func (cs *commentService) List(articleId uint, limit int, cursor string) (*[]models.Comment, string, error) {
+	query := cs.db.
+		Preload(clause.Associations).
+		Where("article_id = ?", articleId)
+
+	return cs.paginate(query, limit, cursor)
+}
+
+// ListRoots returns a page of the top level comments of the article
+func (cs *commentService) ListRoots(articleId uint, limit int, cursor string) (*[]models.Comment, string, error) {
+	query := cs.db.
+		Preload(clause.Associations).
+		Where("article_id = ? AND parent_id IS NULL", articleId)
+
+	return cs.paginate(query, limit, cursor)
+}
+
+// Thread returns the given comments and all of their replies
+func (cs *commentService) Thread(rootIds []uint) (*[]models.Comment, error) {
 	var c []models.Comment
 	result := cs.db.
 		Preload(clause.Associations).
-		Joins("LEFT JOIN \"articles\" on \"articles\".id = comments.article_id").
-		Where("\"articles\".slug = ?", slug).
+		Where(`id IN (
+			WITH RECURSIVE thread AS (
+				SELECT id FROM comments WHERE id IN ?
+				UNION ALL
+				SELECT comments.id FROM comments JOIN thread ON comments.parent_id = thread.id
+			)
+			SELECT id FROM thread
+		)`, rootIds).
+		Order("id ASC").
 		Find(&c)
 
 	return &c, result.Error
 }
 
+// Delete removes the comment. Comments that still have replies are turned into
+// a tombstone instead, so that the replies stay attached to the thread.
+// Tombstoned parents are removed once their last reply is gone.
 func (cs *commentService) Delete(comment models.Comment) error {
-	result := cs.db.Delete(&comment)
-	return result.Error
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		return deleteComment(tx, comment)
+	})
+}
+
+func deleteComment(tx *gorm.DB, comment models.Comment) error {
+	current := &comment
+	for {
+		var replies int64
+		if err := tx.Model(&models.Comment{}).Where("parent_id = ?", current.ID).Count(&replies).Error; err != nil {
+			return err
+		}
+
+		if replies > 0 {
+			return tx.Model(current).Updates(map[string]interface{}{
+				"deleted": true,
+				"body":    "",
+			}).Error
+		}
+
+		if err := tx.Where("comment_id = ?", current.ID).Delete(&models.CommentEdit{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&models.Comment{}, current.ID).Error; err != nil {
+			return err
+		}
+
+		if current.ParentID == nil {
+			return nil
+		}
+
+		var parent models.Comment
+		if err := tx.First(&parent, *current.ParentID).Error; err != nil {
+			return err
+		}
+
+		if !parent.Deleted {
+			return nil
+		}
+		current = &parent
+	}
 }
 
 func (cs *commentService) Get(id uint) (*models.Comment, error) {
@@ -48,3 +163,69 @@ func (cs *commentService) Get(id uint) (*models.Comment, error) {
 	result := cs.db.First(&c, "id = ?", id)
 	return &c, result.Error
 }
+
+// Update replaces the body of the comment and keeps the previous body as an edit
+func (cs *commentService) Update(comment *models.Comment, body string, editorId uint) error {
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		edit := models.CommentEdit{
+			CommentID: comment.ID,
+			Body:      comment.Body,
+			EditorID:  editorId,
+		}
+
+		if err := tx.Create(&edit).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		comment.Body = body
+		comment.EditedAt = &now
+
+		return tx.Model(comment).Updates(map[string]interface{}{
+			"body":      comment.Body,
+			"edited_at": comment.EditedAt,
+		}).Error
+	})
+}
+
+// History returns the previous versions of the comment, most recent first
+func (cs *commentService) History(commentId uint) (*[]models.CommentEdit, error) {
+	var e []models.CommentEdit
+	result := cs.db.
+		Preload("Editor").
+		Where("comment_id = ?", commentId).
+		Order("id DESC").
+		Find(&e)
+
+	return &e, result.Error
+}
+
+// paginate orders the comments by id, which follows their creation order,
+// and returns the page after the cursor together with the next cursor
+func (cs *commentService) paginate(query *gorm.DB, limit int, cursor string) (*[]models.Comment, string, error) {
+	var c []models.Comment
+
+	if cursor != "" {
+		decoded, err := utils.DecodeCursor(cs.secret, cursor)
+		if err != nil || decoded.Order != "COMMENTS" {
+			return nil, "", apperrors.NewBadRequest(utils.ErrInvalidCursor.Error())
+		}
+		query.Where("comments.id > ?", decoded.Id)
+	}
+
+	if err := query.Order("comments.id ASC").Limit(limit + 1).Find(&c).Error; err != nil {
+		return nil, "", err
+	}
+
+	if len(c) <= limit {
+		return &c, "", nil
+	}
+
+	c = c[:limit]
+	next, err := utils.EncodeCursor(cs.secret, utils.Cursor{
+		Order: "COMMENTS",
+		Id:    c[limit-1].ID,
+	})
+
+	return &c, next, err
+}
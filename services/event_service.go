@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventsChannel = "events"
+	eventsStream  = "events:stream"
+	// eventReplayLimit is the most events a reconnecting client gets replayed
+	eventReplayLimit = 1000
+	// subscriptionBuffer is how many events a slow connection can fall behind
+	// before it is closed and has to resume with Last-Event-ID
+	subscriptionBuffer       = 64
+	defaultEventStreamLength = 10000
+)
+
+// UserTopic receives the events meant for a single user
+func UserTopic(userId uint) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// ArticleTopic receives the events of an article, like new comments
+func ArticleTopic(articleId uint) string {
+	return fmt.Sprintf("article:%d", articleId)
+}
+
+// FeedTopic receives the articles an author publishes
+func FeedTopic(authorId uint) string {
+	return fmt.Sprintf("feed:%d", authorId)
+}
+
+// EventSubscription receives the events of its topics on this instance.
+// Events is closed when the subscription is removed or falls behind.
+type EventSubscription struct {
+	Events chan models.Event
+	topics map[string]bool
+	closed bool
+}
+
+// EventService distributes events to the connected clients of all instances.
+// Every event is appended to a bounded Redis stream, so that clients can resume
+// after a reconnect, and is fanned out to the instances through pub/sub.
+type EventService interface {
+	Start(ctx context.Context)
+	Publish(ctx context.Context, topic string, kind string, data interface{}) error
+	Subscribe(topics []string) *EventSubscription
+	Unsubscribe(sub *EventSubscription)
+	Replay(ctx context.Context, lastId string, topics []string) ([]models.Event, bool, error)
+}
+
+type eventService struct {
+	redis        *redis.Client
+	streamLength int64
+
+	mu            sync.Mutex
+	subscriptions map[*EventSubscription]bool
+}
+
+func NewEventService(rc database.RedisConnection, c *config.Config) EventService {
+	length := c.Get().GetInt64("events.streamLength")
+	if length <= 0 {
+		length = defaultEventStreamLength
+	}
+
+	return &eventService{
+		redis:         rc.Get(),
+		streamLength:  length,
+		subscriptions: make(map[*EventSubscription]bool),
+	}
+}
+
+// Start listens for the events of all instances until the context is cancelled
+func (es *eventService) Start(ctx context.Context) {
+	go func() {
+		for {
+			es.listen(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+				log.Warn("event subscription lost, reconnecting")
+			}
+		}
+	}()
+}
+
+func (es *eventService) listen(ctx context.Context) {
+	pubsub := es.redis.Subscribe(ctx, eventsChannel)
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			log.Errorf("error receiving event: %v", err)
+			return
+		}
+
+		var e models.Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			log.Errorf("error decoding event: %v", err)
+			continue
+		}
+
+		es.dispatch(e)
+	}
+}
+
+// Publish appends the event to the stream and sends it to every instance
+func (es *eventService) Publish(ctx context.Context, topic string, kind string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	e := models.Event{
+		Type:  kind,
+		Topic: topic,
+		Data:  payload,
+	}
+
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	id, err := es.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream:       eventsStream,
+		MaxLenApprox: es.streamLength,
+		ID:           "*",
+		Values:       map[string]interface{}{"event": value},
+	}).Result()
+
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	message, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return es.redis.Publish(ctx, eventsChannel, message).Err()
+}
+
+func (es *eventService) Subscribe(topics []string) *EventSubscription {
+	sub := &EventSubscription{
+		Events: make(chan models.Event, subscriptionBuffer),
+		topics: make(map[string]bool),
+	}
+
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	es.mu.Lock()
+	es.subscriptions[sub] = true
+	es.mu.Unlock()
+
+	return sub
+}
+
+func (es *eventService) Unsubscribe(sub *EventSubscription) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	delete(es.subscriptions, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.Events)
+	}
+}
+
+func (es *eventService) dispatch(e models.Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	for sub := range es.subscriptions {
+		if !sub.topics[e.Topic] {
+			continue
+		}
+
+		select {
+		case sub.Events <- e:
+		default:
+			// The connection cannot keep up, so it is closed and resumes later
+			delete(es.subscriptions, sub)
+			sub.closed = true
+			close(sub.Events)
+		}
+	}
+}
+
+// Replay returns the events of the topics after lastId. The returned bool is
+// false if older events have already been trimmed from the stream or if there
+// are more than eventReplayLimit of them, so that some may have been missed.
+func (es *eventService) Replay(ctx context.Context, lastId string, topics []string) ([]models.Event, bool, error) {
+	first, err := es.redis.XRangeN(ctx, eventsStream, "-", "+", 1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(first) == 0 {
+		return nil, true, nil
+	}
+
+	complete := CompareEventIDs(lastId, first[0].ID) >= 0
+
+	messages, err := es.redis.XRangeN(ctx, eventsStream, lastId, "+", eventReplayLimit+1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, topic := range topics {
+		wanted[topic] = true
+	}
+
+	events := make([]models.Event, 0)
+	for i, msg := range messages {
+		if i == eventReplayLimit {
+			complete = false
+			break
+		}
+
+		// XRANGE includes the start itself
+		if msg.ID == lastId {
+			continue
+		}
+
+		value, _ := msg.Values["event"].(string)
+
+		var e models.Event
+		if err := json.Unmarshal([]byte(value), &e); err != nil {
+			continue
+		}
+
+		if wanted[e.Topic] {
+			e.ID = msg.ID
+			events = append(events, e)
+		}
+	}
+
+	return events, complete, nil
+}
+
+// CompareEventIDs compares two stream ids of the form <milliseconds>-<sequence>
+func CompareEventIDs(a string, b string) int {
+	am, as := parseEventID(a)
+	bm, bs := parseEventID(b)
+
+	switch {
+	case am < bm:
+		return -1
+	case am > bm:
+		return 1
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ValidEventID checks if the id is a stream id a client may resume from
+func ValidEventID(id string) bool {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func parseEventID(id string) (uint64, uint64) {
+	parts := strings.SplitN(id, "-", 2)
+	ms, _ := strconv.ParseUint(parts[0], 10, 64)
+
+	var seq uint64
+	if len(parts) == 2 {
+		seq, _ = strconv.ParseUint(parts[1], 10, 64)
+	}
+	return ms, seq
+}
+
+// publishEvent sends an event on behalf of another service. Like notify,
+// a failure is only logged and never fails the action itself.
+func publishEvent(es EventService, topic string, kind string, data interface{}) {
+	if err := es.Publish(context.Background(), topic, kind, data); err != nil {
+		log.Errorf("error publishing %s event: %v", kind, err)
+	}
+}
@@ -3,81 +3,126 @@ package services
 import (
 	"bytes"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/disintegration/imaging"
 	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	"github.com/sentrionic/OlympusGin/utils"
 	"image"
 	"image/jpeg"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net/http"
 	"regexp"
 	"strings"
 )
 
+// DimMax is the largest width of the JPEG variant used as the main image,
+// for clients that do not support srcset
 const DimMax = 1080
-const DimMin = 320
+
+// ImageWidths are the widths of the variants created for every uploaded image.
+// Images are never scaled up, so smaller images get fewer variants.
+var ImageWidths = []int{320, 640, 1080, 1600}
+
+// Default limits of uploaded images, which can be changed with images.maxBytes
+// and images.maxPixels. The pixel limit protects against decompression bombs,
+// small files that decode to huge images.
+const (
+	defaultMaxImageBytes  = 10 << 20
+	defaultMaxImagePixels = 40_000_000
+)
+
+// decodableImageTypes are the formats uploads may have, detected from the
+// content itself. They map to the names registered with the image package.
+var decodableImageTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+}
+
+// imageFormat is a format every variant is encoded in
+type imageFormat struct {
+	extension   string
+	contentType string
+	encode      func(w io.Writer, img image.Image) error
+}
+
+// imageFormats always contains JPEG. WebP is added in file_service_webp.go
+// when the binary is built with cgo.
+var imageFormats = []imageFormat{
+	{
+		extension:   "jpeg",
+		contentType: "image/jpeg",
+		encode: func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 75})
+		},
+	},
+}
+
+// OwnedKeyPrefix is where all files uploaded by users are stored. Only files
+// below it are ever deleted, so that nothing else in the bucket can be lost.
+const OwnedKeyPrefix = "files/gin/users/"
+
+// UploadedImage is an image stored in all its variants. Key, URL, Width and Height
+// describe the main image, the widest JPEG variant of at most DimMax pixels.
+// Avatars are only stored once and have no variants.
+type UploadedImage struct {
+	Key      string
+	URL      string
+	Width    int
+	Height   int
+	Variants models.ImageVariants
+	// KeyPrefix is the beginning of the key that all variants share
+	KeyPrefix string
+	// Size is the number of bytes stored for all variants together
+	Size int64
+}
 
 type FileService interface {
-	UploadAvatar(image *multipart.FileHeader, directory string) (string, error)
-	UploadImage(image *multipart.FileHeader, directory string) (string, error)
+	UploadAvatar(image *multipart.FileHeader, directory string) (*UploadedImage, error)
+	UploadImage(image *multipart.FileHeader, directory string) (*UploadedImage, error)
 	DeleteImage(key string) error
+	GetImage(key string) (io.ReadCloser, error)
+	VerifyURL(key string, expires string, signature string) bool
+	ListImages(prefix string) ([]StoredObject, error)
 }
 
 type fileService struct {
-	sess   *session.Session
-	bucket string
+	storage   Storage
+	maxBytes  int64
+	maxPixels int64
 }
 
 func NewFileService(c *config.Config) FileService {
-
 	cfg := c.Get()
-	accessKey := cfg.GetString("aws.access_key")
-	secretKey := cfg.GetString("aws.secret_access_key")
-	region := cfg.GetString("aws.region")
-	bucket := cfg.GetString("aws.storage_bucket_name")
-
-	sess, err := session.NewSession(
-		&aws.Config{
-			Credentials: credentials.NewStaticCredentials(
-				accessKey,
-				secretKey,
-				"",
-			),
-			Region: aws.String(region),
-		},
-	)
 
-	if err != nil {
-		panic("error initializing s3")
+	maxBytes := cfg.GetInt64("images.maxBytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxImageBytes
+	}
+
+	maxPixels := cfg.GetInt64("images.maxPixels")
+	if maxPixels <= 0 {
+		maxPixels = defaultMaxImagePixels
 	}
 
 	return &fileService{
-		sess:   sess,
-		bucket: bucket,
+		storage:   newStorage(c),
+		maxBytes:  maxBytes,
+		maxPixels: maxPixels,
 	}
 }
 
-func (fs *fileService) UploadAvatar(header *multipart.FileHeader, directory string) (string, error) {
-	uploader := s3manager.NewUploader(fs.sess)
-
+func (fs *fileService) UploadAvatar(header *multipart.FileHeader, directory string) (*UploadedImage, error) {
 	key := fmt.Sprintf("files/%s/avatar.jpeg", directory)
 
-	file, err := header.Open()
-
-	if err != nil {
-		return "", err
-	}
-
-	src, _, err := image.Decode(file)
+	src, err := fs.decode(header)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	img := imaging.Resize(src, 150, 0, imaging.Lanczos)
@@ -86,93 +131,213 @@ func (fs *fileService) UploadAvatar(header *multipart.FileHeader, directory stri
 	err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 75})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	up, err := uploader.Upload(&s3manager.UploadInput{
-		Body:        buf,
-		Bucket:      aws.String(fs.bucket),
-		ContentType: aws.String("image/jpeg"),
-		Key:         aws.String(key),
-	})
+	url, err := fs.storage.Put(key, buf.Bytes(), "image/jpeg")
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return up.Location, nil
+	return &UploadedImage{
+		Key:    key,
+		URL:    url,
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		Size:   int64(buf.Len()),
+	}, nil
 }
 
-func (fs *fileService) UploadImage(header *multipart.FileHeader, directory string) (string, error) {
-	uploader := s3manager.NewUploader(fs.sess)
+// UploadImage stores the image in every width of ImageWidths up to its own
+// width, keeping the aspect ratio, and in every format of imageFormats
+func (fs *fileService) UploadImage(header *multipart.FileHeader, directory string) (*UploadedImage, error) {
+	name := formatName(header.Filename)
 
-	key := fmt.Sprintf("files/%s/%s", directory, formatName(header.Filename))
+	src, err := fs.decode(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := &UploadedImage{
+		Variants:  make(models.ImageVariants, 0),
+		KeyPrefix: fmt.Sprintf("files/%s/%s-", directory, name),
+	}
+
+	for _, width := range variantWidths(src.Bounds().Dx()) {
+		img := imaging.Resize(src, width, 0, imaging.Lanczos)
+		height := img.Bounds().Dy()
+
+		for _, format := range imageFormats {
+			buf := new(bytes.Buffer)
+			err = format.encode(buf, img)
+
+			if err != nil {
+				fs.deleteVariants(uploaded.Variants)
+				return nil, err
+			}
+
+			key := fmt.Sprintf("%s%dw.%s", uploaded.KeyPrefix, width, format.extension)
+			url, err := fs.storage.Put(key, buf.Bytes(), format.contentType)
+
+			if err != nil {
+				fs.deleteVariants(uploaded.Variants)
+				return nil, err
+			}
+
+			uploaded.Size += int64(buf.Len())
+
+			uploaded.Variants = append(uploaded.Variants, models.ImageVariant{
+				Key:    key,
+				URL:    url,
+				Type:   format.contentType,
+				Width:  width,
+				Height: height,
+			})
+
+			// The widths are ascending, so the last JPEG that fits wins
+			if format.contentType == "image/jpeg" && (width <= DimMax || uploaded.URL == "") {
+				uploaded.Key = key
+				uploaded.URL = url
+				uploaded.Width = width
+				uploaded.Height = height
+			}
+		}
+	}
+
+	return uploaded, nil
+}
+
+// decode reads an uploaded image without trusting anything the client sent.
+// The type is detected from the content, and the size and dimensions are
+// checked before the pixels are decoded. The image is rotated according to
+// its EXIF orientation. Since only the pixels are kept and every variant is
+// encoded from scratch, EXIF data like GPS positions never reaches the storage.
+func (fs *fileService) decode(header *multipart.FileHeader) (image.Image, error) {
+	if header.Size > fs.maxBytes {
+		return nil, apperrors.NewPayloadTooLarge(fs.maxBytes, header.Size)
+	}
 
 	file, err := header.Open()
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer file.Close()
 
-	src, _, err := image.Decode(file)
+	// The header size is only what the client claims
+	data, err := ioutil.ReadAll(io.LimitReader(file, fs.maxBytes+1))
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	b := src.Bounds()
-	width := b.Dx()
-	height := b.Dy()
+	if size := int64(len(data)); size > fs.maxBytes {
+		return nil, apperrors.NewPayloadTooLarge(fs.maxBytes, size)
+	}
 
-	var img *image.NRGBA
-	if height < DimMin || width < DimMin {
-		img = imaging.Resize(src, DimMin, 0, imaging.Lanczos)
-	} else if height > DimMax && height > width {
-		img = imaging.Fit(src, width, DimMax, imaging.Lanczos)
-	} else if width > DimMax && width > height {
-		img = imaging.Fit(src, DimMax, height, imaging.Lanczos)
-	} else {
-		img = imaging.Fill(src, DimMax, DimMax, imaging.Center, imaging.Lanczos)
+	format, ok := decodableImageTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, apperrors.NewBadRequest("image must be 'image/jpeg' or 'image/png'")
 	}
 
-	buf := new(bytes.Buffer)
-	err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 75})
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
 
-	if err != nil {
-		return "", err
+	if err != nil || decoded != format || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, apperrors.NewBadRequest("invalid image")
 	}
 
-	up, err := uploader.Upload(&s3manager.UploadInput{
-		Body:        buf,
-		Bucket:      aws.String(fs.bucket),
-		ContentType: aws.String("image/jpeg"),
-		Key:         aws.String(key),
-	})
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > fs.maxPixels {
+		return nil, apperrors.NewImageTooLarge(fs.maxPixels, cfg.Width, cfg.Height)
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
 
 	if err != nil {
-		return "", err
+		return nil, apperrors.NewBadRequest("invalid image")
 	}
 
-	return up.Location, nil
+	return img, nil
+}
+
+// variantWidths returns the widths of ImageWidths below the width of the image
+// followed by the width of the image itself, if it is not wider than all of them
+func variantWidths(width int) []int {
+	var widths []int
+	for _, w := range ImageWidths {
+		if w >= width {
+			return append(widths, width)
+		}
+		widths = append(widths, w)
+	}
+	return widths
+}
+
+// deleteVariants cleans up after a failed upload
+func (fs *fileService) deleteVariants(variants models.ImageVariants) {
+	for _, v := range variants {
+		_ = fs.DeleteImage(v.Key)
+	}
 }
 
-var re = regexp.MustCompile(`/[^a-z0-9]/g`)
+var re = regexp.MustCompile(`[^a-z0-9]+`)
 
+// maxNameLength limits the part of the storage key taken from the filename
+const maxNameLength = 50
+
+// formatName turns the filename into a key that needs no escaping in URLs, so that
+// a key can be found in the article bodies that embed it. Everything but ASCII
+// letters and digits is replaced with dashes.
 func formatName(filename string) string {
 	pre := utils.RandomString(5)
 	index := strings.LastIndex(filename, ".")
-	filename = filename[:index]
+	if index >= 0 {
+		filename = filename[:index]
+	}
 	filename = strings.ToLower(filename)
 	filename = re.ReplaceAllString(filename, "-")
-	return fmt.Sprintf("%s-%s.jpeg", pre, filename)
+	if len(filename) > maxNameLength {
+		filename = filename[:maxNameLength]
+	}
+	filename = strings.Trim(filename, "-")
+	if filename == "" {
+		return pre
+	}
+	return fmt.Sprintf("%s-%s", pre, filename)
 }
 
+// DeleteImage removes the file stored under the key. Keys outside of
+// OwnedKeyPrefix, like the empty key of placeholder images, are ignored.
 func (fs *fileService) DeleteImage(key string) error {
-	srv := s3.New(fs.sess)
-	_, err := srv.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(fs.bucket),
-		Key:    aws.String(key),
-	})
+	if !ownsKey(key) {
+		return nil
+	}
+
+	return fs.storage.Delete(key)
+}
+
+// GetImage opens the file stored under the key
+func (fs *fileService) GetImage(key string) (io.ReadCloser, error) {
+	if !ownsKey(key) {
+		return nil, apperrors.NewNotFound("file", key)
+	}
+
+	return fs.storage.Get(key)
+}
+
+// VerifyURL checks the signature of a URL handed out by a driver that is served
+// by the app. It always fails for drivers like S3 that serve their files themselves.
+func (fs *fileService) VerifyURL(key string, expires string, signature string) bool {
+	served, ok := fs.storage.(servedStorage)
+	return ok && served.Verify(key, expires, signature)
+}
+
+// ListImages returns the stored files whose keys start with the prefix
+func (fs *fileService) ListImages(prefix string) ([]StoredObject, error) {
+	return fs.storage.List(prefix)
+}
 
-	return err
+func ownsKey(key string) bool {
+	return strings.HasPrefix(key, OwnedKeyPrefix) && !strings.Contains(key, "..")
 }
@@ -0,0 +1,21 @@
+//go:build cgo
+// +build cgo
+
+package services
+
+import (
+	"github.com/chai2010/webp"
+	"image"
+	"io"
+)
+
+// The WebP encoder wraps libwebp, so builds with CGO_ENABLED=0 only serve JPEG variants
+func init() {
+	imageFormats = append(imageFormats, imageFormat{
+		extension:   "webp",
+		contentType: "image/webp",
+		encode: func(w io.Writer, img image.Image) error {
+			return webp.Encode(w, img, &webp.Options{Quality: 75})
+		},
+	})
+}
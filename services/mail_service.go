@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/sentrionic/OlympusGin/config"
 	"net/smtp"
+	"time"
 )
 
 type ResetInput struct {
@@ -11,8 +12,21 @@ type ResetInput struct {
 	Token string
 }
 
+type VerifyInput struct {
+	Email string
+	Token string
+}
+
+type LockoutInput struct {
+	Email    string
+	IP       string
+	Duration time.Duration
+}
+
 type MailService interface {
 	SendResetEmail(in ResetInput)
+	SendVerificationEmail(in VerifyInput)
+	SendLockoutEmail(in LockoutInput)
 }
 
 type mailService struct {
@@ -52,3 +66,41 @@ func (ms *mailService) SendResetEmail(in ResetInput) {
 		return
 	}
 }
+
+func (ms *mailService) SendVerificationEmail(in VerifyInput) {
+	from := ms.user
+	pass := ms.password
+
+	msg := "From: " + from + "\n" +
+		"To: " + in.Email + "\n" +
+		"Subject: Verify Email\n\n" +
+		fmt.Sprintf("<a href=\"%s/verify-email/%s\">Verify Email</a>", ms.origin, in.Token)
+
+	err := smtp.SendMail("smtp.gmail.com:587",
+		smtp.PlainAuth("", from, pass, "smtp.gmail.com"),
+		from, []string{in.Email}, []byte(msg))
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
+func (ms *mailService) SendLockoutEmail(in LockoutInput) {
+	from := ms.user
+	pass := ms.password
+
+	msg := "From: " + from + "\n" +
+		"To: " + in.Email + "\n" +
+		"Subject: Account Locked\n\n" +
+		fmt.Sprintf("Your account has been locked for %v after too many failed login attempts from %s. ", in.Duration, in.IP) +
+		fmt.Sprintf("If this wasn't you, <a href=\"%s/forgot-password\">reset your password</a>.", ms.origin)
+
+	err := smtp.SendMail("smtp.gmail.com:587",
+		smtp.PlainAuth("", from, pass, "smtp.gmail.com"),
+		from, []string{in.Email}, []byte(msg))
+
+	if err != nil {
+		fmt.Printf("error sending lockout email: %v", err)
+	}
+}
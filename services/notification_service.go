@@ -0,0 +1,143 @@
+package services
+
+import (
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type NotificationService interface {
+	Notify(userId uint, kind string, articleId *uint, actorId uint) error
+	List(userId uint, limit int, page int) (*[]models.Notification, error)
+	UnreadCount(userId uint) (int64, error)
+	MarkRead(userId uint, id uint) error
+	MarkAllRead(userId uint) error
+}
+
+type notificationService struct {
+	db *gorm.DB
+	es EventService
+}
+
+func NewNotificationService(conn database.Connection, es EventService) NotificationService {
+	return &notificationService{
+		db: conn.Get(),
+		es: es,
+	}
+}
+
+// Notify adds the actor to the unread notification of the same group
+// or starts a new one. Users are not notified about their own actions.
+func (ns *notificationService) Notify(userId uint, kind string, articleId *uint, actorId uint) error {
+	if userId == actorId {
+		return nil
+	}
+
+	var id uint
+	err := ns.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		err := tx.Raw(`
+			INSERT INTO notifications (user_id, type, article_id, actor_id, actor_count, created_at, updated_at)
+			VALUES (?, ?, ?, ?, 0, ?, ?)
+			ON CONFLICT (user_id, type, (COALESCE(article_id, 0))) WHERE read_at IS NULL
+			DO UPDATE SET actor_id = EXCLUDED.actor_id, updated_at = EXCLUDED.updated_at
+			RETURNING id`,
+			userId, kind, articleId, actorId, now, now,
+		).Scan(&id).Error
+
+		if err != nil {
+			return err
+		}
+
+		result := tx.Exec(
+			"INSERT INTO notification_actors (notification_id, actor_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			id, actorId, now,
+		)
+
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		return tx.Exec("UPDATE notifications SET actor_count = actor_count + 1 WHERE id = ?", id).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	unread, err := ns.UnreadCount(userId)
+	if err != nil {
+		return err
+	}
+
+	publishEvent(ns.es, UserTopic(userId), models.EventNotification, map[string]interface{}{
+		"id":          id,
+		"type":        kind,
+		"unreadCount": unread,
+	})
+	return nil
+}
+
+// List returns the notifications of the user, the most recently active first
+func (ns *notificationService) List(userId uint, limit int, page int) (*[]models.Notification, error) {
+	var n []models.Notification
+	query := ns.db.
+		Preload("Actor").
+		Preload("Article").
+		Where("user_id = ?", userId).
+		Order("updated_at DESC, id DESC").
+		Limit(limit)
+
+	if page > 1 {
+		query.Offset((page - 1) * limit)
+	}
+
+	result := query.Find(&n)
+	return &n, result.Error
+}
+
+func (ns *notificationService) UnreadCount(userId uint) (int64, error) {
+	var count int64
+	result := ns.db.
+		Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Count(&count)
+
+	return count, result.Error
+}
+
+func (ns *notificationService) MarkRead(userId uint, id uint) error {
+	result := ns.db.
+		Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", id, userId).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (ns *notificationService) MarkAllRead(userId uint) error {
+	result := ns.db.
+		Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Update("read_at", time.Now())
+
+	return result.Error
+}
+
+// notify sends a notification on behalf of another service. A failed
+// notification is only logged, so that it never fails the action itself.
+func notify(ns NotificationService, userId uint, kind string, articleId *uint, actorId uint) {
+	if err := ns.Notify(userId, kind, articleId, actorId); err != nil {
+		log.Errorf("error creating %s notification: %v", kind, err)
+	}
+}
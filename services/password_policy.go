@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	passwordMaxLength        = 128
+	breachedPrefixLength     = 5
+)
+
+// PasswordPolicy decides which passwords users are allowed to choose
+type PasswordPolicy interface {
+	Validate(password string, username string, email string) []string
+}
+
+type passwordPolicy struct {
+	minLength int
+	// breached maps the first five hex characters of the SHA-1 hash of a password
+	// to the remaining characters, the same k-anonymity split the
+	// Have I Been Pwned range API uses
+	breached map[string]map[string]struct{}
+}
+
+// NewPasswordPolicy reads password.minLength and loads the list of breached
+// or common passwords from the file at password.breachedList. Each line holds
+// either a SHA-1 hash, optionally followed by :count, or a plain password.
+func NewPasswordPolicy(c *config.Config) PasswordPolicy {
+	cfg := c.Get()
+
+	minLength := cfg.GetInt("password.minLength")
+	if minLength <= 0 {
+		minLength = defaultPasswordMinLength
+	}
+
+	pp := &passwordPolicy{
+		minLength: minLength,
+		breached:  make(map[string]map[string]struct{}),
+	}
+
+	if path := cfg.GetString("password.breachedList"); path != "" {
+		count, err := pp.load(path)
+		if err != nil {
+			log.Fatalf("error loading breached password list: %v", err)
+		}
+		log.Infof("loaded %d breached passwords", count)
+	}
+
+	return pp
+}
+
+// Validate returns the reasons the password is rejected, which is empty for a valid password
+func (pp *passwordPolicy) Validate(password string, username string, email string) []string {
+	var reasons []string
+
+	length := utf8.RuneCountInString(password)
+	if length < pp.minLength {
+		reasons = append(reasons, fmt.Sprintf("Password must be at least %d characters long", pp.minLength))
+	}
+
+	if length > passwordMaxLength {
+		reasons = append(reasons, fmt.Sprintf("Password must be at most %d characters long", passwordMaxLength))
+	}
+
+	lower := strings.ToLower(password)
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		reasons = append(reasons, "Password must not contain the username")
+	}
+
+	if email != "" {
+		local := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+		if strings.Contains(lower, strings.ToLower(email)) || (len(local) >= 3 && strings.Contains(lower, local)) {
+			reasons = append(reasons, "Password must not contain the email")
+		}
+	}
+
+	if pp.isBreached(password) {
+		reasons = append(reasons, "Password is too common or has appeared in a data breach")
+	}
+
+	return reasons
+}
+
+func (pp *passwordPolicy) isBreached(password string) bool {
+	prefix, suffix := splitPasswordHash(sha1Hex(password))
+	_, ok := pp.breached[prefix][suffix]
+	return ok
+}
+
+func (pp *passwordPolicy) load(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash := line
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			hash = line[:i]
+		}
+
+		hash = strings.ToUpper(hash)
+		if !isSHA1Hex(hash) {
+			hash = sha1Hex(line)
+		}
+
+		prefix, suffix := splitPasswordHash(hash)
+		if pp.breached[prefix] == nil {
+			pp.breached[prefix] = make(map[string]struct{})
+		}
+		pp.breached[prefix][suffix] = struct{}{}
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func splitPasswordHash(hash string) (string, string) {
+	return hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+}
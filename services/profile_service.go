@@ -11,14 +11,19 @@ type ProfileService interface {
 	GetByUsername(username string) (*models.User, error)
 	FollowUser(user models.User, current models.User) error
 	UnfollowUser(user models.User, current models.User) error
+	FollowingIds(userId uint) ([]uint, error)
 }
 
 type profileService struct {
 	db *gorm.DB
+	ns NotificationService
 }
 
-func NewProfileService(conn database.Connection) ProfileService {
-	return &profileService{db: conn.Get()}
+func NewProfileService(conn database.Connection, ns NotificationService) ProfileService {
+	return &profileService{
+		db: conn.Get(),
+		ns: ns,
+	}
 }
 
 func (ps *profileService) SearchByUsername(username string) (*[]models.User, error) {
@@ -49,6 +54,10 @@ func (ps *profileService) FollowUser(user models.User, current models.User) erro
 		"followee_id": user.ID,
 		"user_id":     current.ID,
 	}).Error
+
+	if err == nil {
+		notify(ps.ns, user.ID, models.NotificationFollow, nil, current.ID)
+	}
 	return err
 }
 
@@ -59,3 +68,14 @@ func (ps *profileService) UnfollowUser(user models.User, current models.User) er
 		Error
 	return err
 }
+
+// FollowingIds returns the ids of the users the user follows
+func (ps *profileService) FollowingIds(userId uint) ([]uint, error) {
+	var ids []uint
+	result := ps.db.
+		Table("followers").
+		Where("follower_id = ?", userId).
+		Pluck("user_id", &ids)
+
+	return ids, result.Error
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"github.com/sentrionic/OlympusGin/config"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// PublishScheduler periodically publishes articles whose publishAt time has passed
+type PublishScheduler interface {
+	Start(ctx context.Context)
+}
+
+type publishScheduler struct {
+	as       ArticleService
+	interval time.Duration
+}
+
+func NewPublishScheduler(as ArticleService, c *config.Config) PublishScheduler {
+	interval := c.Get().GetDuration("scheduler.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &publishScheduler{
+		as:       as,
+		interval: interval,
+	}
+}
+
+// Start runs the scheduler in the background until the context is cancelled
+func (ps *publishScheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ps.interval)
+		defer ticker.Stop()
+
+		for {
+			ps.run()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (ps *publishScheduler) run() {
+	ids, err := ps.as.PublishDue()
+
+	if err != nil {
+		log.Errorf("error publishing scheduled articles: %v", err)
+		return
+	}
+
+	if len(ids) > 0 {
+		log.Infof("published %d scheduled articles", len(ids))
+	}
+}
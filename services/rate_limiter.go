@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/database"
+	"strings"
+	"time"
+)
+
+// RateLimitPolicy allows Limit requests within a sliding Window
+type RateLimitPolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitResult describes the state of the window after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the oldest request leaves the window
+	Reset time.Duration
+}
+
+// defaultRateLimitPolicies apply unless they are overridden in rateLimit.policies
+var defaultRateLimitPolicies = map[string]RateLimitPolicy{
+	"createArticle":      {Limit: 10, Window: time.Hour},
+	"createComment":      {Limit: 30, Window: time.Hour},
+	"exportData":         {Limit: 5, Window: 24 * time.Hour},
+	"forgotPassword":     {Limit: 3, Window: time.Hour},
+	"resendVerification": {Limit: 3, Window: time.Hour},
+	"uploadImage":        {Limit: 60, Window: time.Hour},
+}
+
+// slidingWindowScript removes the requests that left the window and records the new
+// one if there is room for it. It returns whether the request is allowed, the number
+// of requests in the window and the milliseconds until the oldest one leaves it.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, count, reset}
+`)
+
+type RateLimiter interface {
+	Policy(name string) (RateLimitPolicy, bool)
+	Allow(ctx context.Context, name string, key string) (*RateLimitResult, error)
+}
+
+type rateLimiter struct {
+	redis    *redis.Client
+	policies map[string]RateLimitPolicy
+}
+
+// NewRateLimiter reads the policies from rateLimit.policies.{name}.limit and .window,
+// which override the defaults. A policy with a limit of 0 is disabled.
+func NewRateLimiter(rc database.RedisConnection, c *config.Config) RateLimiter {
+	cfg := c.Get()
+	policies := make(map[string]RateLimitPolicy)
+
+	// viper lowercases the keys of the config file
+	for name, policy := range defaultRateLimitPolicies {
+		policies[strings.ToLower(name)] = policy
+	}
+
+	for name := range cfg.GetStringMap("rateLimit.policies") {
+		policy := policies[name]
+		if key := fmt.Sprintf("rateLimit.policies.%s.limit", name); cfg.IsSet(key) {
+			policy.Limit = cfg.GetInt(key)
+		}
+		if key := fmt.Sprintf("rateLimit.policies.%s.window", name); cfg.IsSet(key) {
+			policy.Window = cfg.GetDuration(key)
+		}
+		policies[name] = policy
+	}
+
+	return &rateLimiter{
+		redis:    rc.Get(),
+		policies: policies,
+	}
+}
+
+// Policy returns the enabled policy with the given name
+func (rl *rateLimiter) Policy(name string) (RateLimitPolicy, bool) {
+	policy, ok := rl.policies[strings.ToLower(name)]
+	return policy, ok && policy.Limit > 0 && policy.Window > 0
+}
+
+// Allow records a request of the key against the policy
+func (rl *rateLimiter) Allow(ctx context.Context, name string, key string) (*RateLimitResult, error) {
+	policy, ok := rl.Policy(name)
+	if !ok {
+		return &RateLimitResult{Allowed: true}, nil
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := slidingWindowScript.Run(
+		ctx,
+		rl.redis,
+		[]string{fmt.Sprintf("rate-limit:%s:%s", name, key)},
+		time.Now().UnixNano()/int64(time.Millisecond),
+		policy.Window.Milliseconds(),
+		policy.Limit,
+		uid.String(),
+	).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply: %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	reset, _ := values[2].(int64)
+
+	remaining := policy.Limit - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   allowed == 1,
+		Limit:     policy.Limit,
+		Remaining: remaining,
+		Reset:     time.Duration(reset) * time.Millisecond,
+	}, nil
+}
@@ -2,19 +2,34 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/sentrionic/OlympusGin/database"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type RedisService interface {
 	SetResetToken(ctx context.Context, id uint) (string, error)
 	GetIdFromToken(ctx context.Context, token string) (uint, error)
+	ConsumeResetToken(ctx context.Context, token string) (uint, error)
+	SetVerifyToken(ctx context.Context, id uint, email string) (string, error)
+	GetVerifyToken(ctx context.Context, token string) (uint, string, error)
 }
 
+// getDelScript returns the value of the key and deletes it in one step,
+// so that a token can only ever be used once
+var getDelScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`)
+
 type redisService struct {
 	redis *redis.Client
 }
@@ -50,7 +65,103 @@ func (r *redisService) GetIdFromToken(ctx context.Context, token string) (uint,
 	}
 	id, _ := strconv.Atoi(val)
 
-	r.redis.Del(ctx, key)
+	return uint(id), nil
+}
+
+// ConsumeResetToken invalidates the token once the password has been reset.
+// It fails if the token has already been used by a concurrent request.
+func (r *redisService) ConsumeResetToken(ctx context.Context, token string) (uint, error) {
+	key := fmt.Sprintf("forgot-password:%s", token)
+	val, err := getDelScript.Run(ctx, r.redis, []string{key}).Text()
+
+	if err != nil {
+		return 0, err
+	}
+	id, _ := strconv.Atoi(val)
 
 	return uint(id), nil
 }
+
+// setVerifyTokenScript stores the new token as the only pending one of the user
+// and deletes the token it replaces, so that only the latest email can be confirmed
+var setVerifyTokenScript = redis.NewScript(`
+local previous = redis.call('GET', KEYS[1])
+if previous then
+	redis.call('DEL', ARGV[1] .. previous)
+end
+redis.call('SET', KEYS[2], ARGV[3], 'PX', ARGV[4])
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[4])
+return 1
+`)
+
+// deletePendingScript deletes the pending token of the user if it is the given one
+var deletePendingScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// SetVerifyToken creates a one-time token that confirms the user owns the email.
+// Tokens created earlier for the user stop working.
+func (r *redisService) SetVerifyToken(ctx context.Context, id uint, email string) (string, error) {
+	uid, err := uuid.NewRandom()
+
+	if err != nil {
+		return "", err
+	}
+
+	value := fmt.Sprintf("%d:%s", id, email)
+	err = setVerifyTokenScript.Run(
+		ctx,
+		r.redis,
+		[]string{pendingVerifyKey(id), verifyKey(uid.String())},
+		verifyKey(""),
+		uid.String(),
+		value,
+		(24 * time.Hour).Milliseconds(),
+	).Err()
+
+	if err != nil {
+		fmt.Println(err)
+		return "", err
+	}
+
+	return uid.String(), nil
+}
+
+// GetVerifyToken returns the user and the email the token was created for and deletes it.
+// It fails if a newer token has been created for the user since.
+func (r *redisService) GetVerifyToken(ctx context.Context, token string) (uint, string, error) {
+	val, err := getDelScript.Run(ctx, r.redis, []string{verifyKey(token)}).Text()
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	parts := strings.SplitN(val, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New("invalid verification token")
+	}
+
+	id, _ := strconv.Atoi(parts[0])
+
+	deleted, err := deletePendingScript.Run(ctx, r.redis, []string{pendingVerifyKey(uint(id))}, token).Int()
+	if err != nil {
+		return 0, "", err
+	}
+
+	if deleted == 0 {
+		return 0, "", errors.New("invalid verification token")
+	}
+
+	return uint(id), parts[1], nil
+}
+
+func verifyKey(token string) string {
+	return fmt.Sprintf("verify-email:%s", token)
+}
+
+func pendingVerifyKey(userId uint) string {
+	return fmt.Sprintf("verify-email-pending:%d", userId)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisService(t *testing.T) *redisService {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &redisService{redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+}
+
+func TestVerifyTokenOnlyLatestIsValid(t *testing.T) {
+	rs := newTestRedisService(t)
+	ctx := context.Background()
+
+	mistyped, err := rs.SetVerifyToken(ctx, 1, "mistyped@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := rs.SetVerifyToken(ctx, 1, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := rs.GetVerifyToken(ctx, mistyped); err == nil {
+		t.Error("a replaced token was accepted")
+	}
+
+	id, email, err := rs.GetVerifyToken(ctx, latest)
+	if err != nil {
+		t.Fatalf("got %v for the latest token", err)
+	}
+	if id != 1 || email != "user@example.com" {
+		t.Errorf("got %d %s, want 1 user@example.com", id, email)
+	}
+
+	if _, _, err := rs.GetVerifyToken(ctx, latest); err == nil {
+		t.Error("a token was accepted twice")
+	}
+}
+
+func TestVerifyTokensOfOtherUsersStayValid(t *testing.T) {
+	rs := newTestRedisService(t)
+	ctx := context.Background()
+
+	first, _ := rs.SetVerifyToken(ctx, 1, "first@example.com")
+	if _, err := rs.SetVerifyToken(ctx, 2, "second@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := rs.GetVerifyToken(ctx, first); err != nil {
+		t.Errorf("got %v, the token of another user was replaced", err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	"gorm.io/gorm"
+)
+
+type RevisionService interface {
+	List(articleId uint) (*[]models.ArticleRevision, error)
+	Get(articleId uint, version int) (*models.ArticleRevision, error)
+}
+
+type revisionService struct {
+	db *gorm.DB
+}
+
+func NewRevisionService(conn database.Connection) RevisionService {
+	return &revisionService{db: conn.Get()}
+}
+
+func (rs *revisionService) List(articleId uint) (*[]models.ArticleRevision, error) {
+	var r []models.ArticleRevision
+	result := rs.db.
+		Preload("Editor").
+		Where("article_id = ?", articleId).
+		Order("version DESC").
+		Find(&r)
+
+	return &r, result.Error
+}
+
+func (rs *revisionService) Get(articleId uint, version int) (*models.ArticleRevision, error) {
+	var r models.ArticleRevision
+	result := rs.db.
+		Preload("Editor").
+		Where("article_id = ? AND version = ?", articleId, version).
+		First(&r)
+
+	return &r, result.Error
+}
+
+// createRevision snapshots the current content of the article as its next version
+func createRevision(tx *gorm.DB, a *models.Article, editorId uint) error {
+	tags := make([]string, 0)
+	for _, t := range a.Tags {
+		tags = append(tags, t.Tag)
+	}
+
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = tx.Model(&models.ArticleRevision{}).
+		Where("article_id = ?", a.ID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error
+	if err != nil {
+		return err
+	}
+
+	r := models.ArticleRevision{
+		ArticleID:   a.ID,
+		Version:     version + 1,
+		Title:       a.Title,
+		Description: a.Description,
+		Body:        a.Body,
+		Tags:        string(encoded),
+		EditorID:    editorId,
+	}
+
+	return tx.Create(&r).Error
+}
+
+// RevisionTags decodes the tag list stored on a revision
+func RevisionTags(r *models.ArticleRevision) []string {
+	var tags []string
+	_ = json.Unmarshal([]byte(r.Tags), &tags)
+	return tags
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage drivers
+const (
+	StorageS3     = "s3"
+	StorageLocal  = "local"
+	StorageMemory = "memory"
+)
+
+// Storage is where the FileService keeps its files. The driver is chosen
+// with storage.driver and defaults to S3.
+type Storage interface {
+	// Put stores the file under the key and returns its public location
+	Put(key string, body []byte, contentType string) (string, error)
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the file. Deleting a missing file is not an error.
+	Delete(key string) error
+	List(prefix string) ([]StoredObject, error)
+}
+
+// StoredObject is a file returned by Storage.List
+type StoredObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// servedStorage is implemented by the drivers whose files are served by the
+// app itself instead of a CDN, using the signed URLs returned by Put
+type servedStorage interface {
+	Verify(key string, expires string, signature string) bool
+}
+
+func newStorage(c *config.Config) Storage {
+	switch driver := c.Get().GetString("storage.driver"); driver {
+	case "", StorageS3:
+		return newS3Storage(c)
+	case StorageLocal:
+		return newLocalStorage(c)
+	case StorageMemory:
+		return newMemoryStorage(c)
+	default:
+		panic(fmt.Sprintf("unknown storage driver %q", driver))
+	}
+}
+
+// urlSigner creates the URLs of the files served by the app. The signature
+// keeps clients from reading anything but the files handed out by the app.
+// As the URLs are stored with the articles, they only expire if storage.urlTTL is set.
+type urlSigner struct {
+	baseURL string
+	secret  []byte
+	ttl     time.Duration
+}
+
+func newURLSigner(c *config.Config) urlSigner {
+	cfg := c.Get()
+
+	return urlSigner{
+		baseURL: strings.TrimSuffix(cfg.GetString("storage.baseUrl"), "/"),
+		secret:  []byte(cfg.GetString("app.secret")),
+		ttl:     cfg.GetDuration("storage.urlTTL"),
+	}
+}
+
+func (s urlSigner) URL(key string) string {
+	query := url.Values{}
+
+	expires := ""
+	if s.ttl > 0 {
+		expires = strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 10)
+		query.Set("expires", expires)
+	}
+
+	query.Set("signature", s.sign(key, expires))
+	return fmt.Sprintf("%s/%s?%s", s.baseURL, key, query.Encode())
+}
+
+func (s urlSigner) Verify(key string, expires string, signature string) bool {
+	if expires != "" {
+		at, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > at {
+			return false
+		}
+	} else if s.ttl > 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(s.sign(key, expires)))
+}
+
+func (s urlSigner) sign(key string, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s", key, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
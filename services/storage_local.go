@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultStoragePath = "uploads"
+
+// localStorage keeps the files on disk below storage.path.
+// They are served by the app under storage.baseUrl with signed URLs.
+type localStorage struct {
+	urlSigner
+	root string
+}
+
+func newLocalStorage(c *config.Config) Storage {
+	root := c.Get().GetString("storage.path")
+	if root == "" {
+		root = defaultStoragePath
+	}
+
+	return &localStorage{
+		urlSigner: newURLSigner(c),
+		root:      root,
+	}
+}
+
+// path returns the location of the key on disk. Cleaning the key as an
+// absolute path keeps it from escaping the root with "..".
+func (ls *localStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", errors.New("invalid storage key")
+	}
+	return filepath.Join(ls.root, filepath.FromSlash(clean)), nil
+}
+
+func (ls *localStorage) Put(key string, body []byte, _ string) (string, error) {
+	name, err := ls.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first, so that readers never see a partial file
+	tmp, err := ioutil.TempFile(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return ls.URL(key), nil
+}
+
+func (ls *localStorage) Get(key string) (io.ReadCloser, error) {
+	name, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, apperrors.NewNotFound("file", key)
+	}
+	return file, err
+}
+
+func (ls *localStorage) List(prefix string) ([]StoredObject, error) {
+	objects := make([]StoredObject, 0)
+
+	err := filepath.Walk(ls.root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		// Skip directories and the temporary files of uploads in progress
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ls.root, name)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			objects = append(objects, StoredObject{
+				Key:          key,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			})
+		}
+		return nil
+	})
+
+	return objects, err
+}
+
+func (ls *localStorage) Delete(key string) error {
+	name, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
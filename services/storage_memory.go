@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bytes"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStorage keeps the files in memory, which is meant for tests and CI.
+// They are served by the app like the files of the local driver.
+type memoryStorage struct {
+	urlSigner
+	mu    sync.RWMutex
+	files map[string]memoryObject
+}
+
+type memoryObject struct {
+	body     []byte
+	modified time.Time
+}
+
+func newMemoryStorage(c *config.Config) Storage {
+	return &memoryStorage{
+		urlSigner: newURLSigner(c),
+		files:     make(map[string]memoryObject),
+	}
+}
+
+func (ms *memoryStorage) Put(key string, body []byte, _ string) (string, error) {
+	file := make([]byte, len(body))
+	copy(file, body)
+
+	ms.mu.Lock()
+	ms.files[key] = memoryObject{body: file, modified: time.Now()}
+	ms.mu.Unlock()
+
+	return ms.URL(key), nil
+}
+
+func (ms *memoryStorage) Get(key string) (io.ReadCloser, error) {
+	ms.mu.RLock()
+	file, ok := ms.files[key]
+	ms.mu.RUnlock()
+
+	if !ok {
+		return nil, apperrors.NewNotFound("file", key)
+	}
+
+	// Files are never modified in place, so readers can share the slice
+	return memoryFile{bytes.NewReader(file.body)}, nil
+}
+
+func (ms *memoryStorage) List(prefix string) ([]StoredObject, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	objects := make([]StoredObject, 0)
+	for key, file := range ms.files {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, StoredObject{
+				Key:          key,
+				Size:         int64(len(file.body)),
+				LastModified: file.modified,
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (ms *memoryStorage) Delete(key string) error {
+	ms.mu.Lock()
+	delete(ms.files, key)
+	ms.mu.Unlock()
+
+	return nil
+}
+
+// memoryFile is seekable, so that it can be served with range requests
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
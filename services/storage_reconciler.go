@@ -0,0 +1,87 @@
+package services
+
+import (
+	"github.com/sentrionic/OlympusGin/database"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// referencedKeysQuery returns every storage key the database still refers to
+const referencedKeysQuery = `
+	SELECT image_key FROM users WHERE image_key <> ''
+	UNION SELECT image_key FROM articles WHERE image_key <> ''
+	UNION SELECT variant->>'key' FROM articles, jsonb_array_elements(articles.image_variants) AS variant
+	UNION SELECT variant->>'key' FROM uploads, jsonb_array_elements(uploads.variants) AS variant
+`
+
+// StorageReconciler finds the files below OwnedKeyPrefix that no user, article
+// or upload references anymore, e.g. because deleting them failed earlier
+type StorageReconciler interface {
+	Reconcile(before time.Time, remove bool) (*ReconcileReport, error)
+}
+
+type ReconcileReport struct {
+	Checked  int
+	Orphaned []StoredObject
+	Removed  int
+}
+
+type storageReconciler struct {
+	db *gorm.DB
+	fs FileService
+}
+
+func NewStorageReconciler(conn database.Connection, fs FileService) StorageReconciler {
+	return &storageReconciler{
+		db: conn.Get(),
+		fs: fs,
+	}
+}
+
+// Reconcile returns the orphaned files last modified before the given time and
+// removes them if remove is set. Newer files are skipped, since they may belong
+// to an upload whose row has not been saved yet.
+func (sr *storageReconciler) Reconcile(before time.Time, remove bool) (*ReconcileReport, error) {
+	// The files are listed first, so that a file uploaded in between is never orphaned
+	objects, err := sr.fs.ListImages(OwnedKeyPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err := sr.db.Raw(referencedKeysQuery).Scan(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		referenced[key] = true
+	}
+
+	report := &ReconcileReport{
+		Checked:  len(objects),
+		Orphaned: make([]StoredObject, 0),
+	}
+
+	for _, object := range objects {
+		if referenced[object.Key] || !object.LastModified.Before(before) {
+			continue
+		}
+
+		report.Orphaned = append(report.Orphaned, object)
+
+		if !remove {
+			continue
+		}
+
+		if err := sr.fs.DeleteImage(object.Key); err != nil {
+			log.Errorf("error deleting %s: %v", object.Key, err)
+			continue
+		}
+		report.Removed++
+	}
+
+	return report, nil
+}
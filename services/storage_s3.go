@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/sentrionic/OlympusGin/config"
+	"io"
+)
+
+// s3Storage keeps the files in an S3 bucket, which also serves them
+type s3Storage struct {
+	sess   *session.Session
+	bucket string
+}
+
+func newS3Storage(c *config.Config) Storage {
+	cfg := c.Get()
+	accessKey := cfg.GetString("aws.access_key")
+	secretKey := cfg.GetString("aws.secret_access_key")
+	region := cfg.GetString("aws.region")
+	bucket := cfg.GetString("aws.storage_bucket_name")
+
+	sess, err := session.NewSession(
+		&aws.Config{
+			Credentials: credentials.NewStaticCredentials(
+				accessKey,
+				secretKey,
+				"",
+			),
+			Region: aws.String(region),
+		},
+	)
+
+	if err != nil {
+		panic("error initializing s3")
+	}
+
+	return &s3Storage{
+		sess:   sess,
+		bucket: bucket,
+	}
+}
+
+func (ss *s3Storage) Put(key string, body []byte, contentType string) (string, error) {
+	uploader := s3manager.NewUploader(ss.sess)
+
+	up, err := uploader.Upload(&s3manager.UploadInput{
+		Body:        bytes.NewReader(body),
+		Bucket:      aws.String(ss.bucket),
+		ContentType: aws.String(contentType),
+		Key:         aws.String(key),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return up.Location, nil
+}
+
+func (ss *s3Storage) Get(key string) (io.ReadCloser, error) {
+	srv := s3.New(ss.sess)
+	out, err := srv.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (ss *s3Storage) List(prefix string) ([]StoredObject, error) {
+	srv := s3.New(ss.sess)
+	objects := make([]StoredObject, 0)
+
+	err := srv.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(ss.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, StoredObject{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+
+	return objects, err
+}
+
+func (ss *s3Storage) Delete(key string) error {
+	srv := s3.New(ss.sess)
+	_, err := srv.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
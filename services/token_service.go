@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/utils"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// TokenPrefix marks personal access tokens so they are easy to recognize
+const TokenPrefix = "olym_"
+
+var errInvalidToken = errors.New("provided token is invalid")
+
+type TokenService interface {
+	Create(userId uint, name string, scopes []string, expiresAt *time.Time) (*models.AccessToken, string, error)
+	List(userId uint) (*[]models.AccessToken, error)
+	Revoke(userId uint, id uint) error
+}
+
+type tokenService struct {
+	db *gorm.DB
+}
+
+func NewTokenService(conn database.Connection) TokenService {
+	return &tokenService{db: conn.Get()}
+}
+
+// Create stores a new token and returns it together with its plain text value,
+// which is not stored and can therefore only be shown once
+func (ts *tokenService) Create(userId uint, name string, scopes []string, expiresAt *time.Time) (*models.AccessToken, string, error) {
+	random, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := TokenPrefix + random
+
+	t := models.AccessToken{
+		UserID:    userId,
+		Name:      name,
+		Prefix:    token[:len(TokenPrefix)+6],
+		TokenHash: utils.HashToken(token),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+
+	result := ts.db.Create(&t)
+	return &t, token, result.Error
+}
+
+func (ts *tokenService) List(userId uint) (*[]models.AccessToken, error) {
+	var t []models.AccessToken
+	result := ts.db.
+		Where("user_id = ?", userId).
+		Order("id DESC").
+		Find(&t)
+
+	return &t, result.Error
+}
+
+func (ts *tokenService) Revoke(userId uint, id uint) error {
+	result := ts.db.
+		Where("id = ? AND user_id = ?", id, userId).
+		Delete(&models.AccessToken{})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TokenScopes returns the scopes of the token
+func TokenScopes(t *models.AccessToken) []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// TokenHasScope checks if the token has been granted the scope
+func TokenHasScope(t *models.AccessToken, scope string) bool {
+	for _, s := range TokenScopes(t) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
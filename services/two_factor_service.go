@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	"github.com/sentrionic/OlympusGin/utils"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTPIssuer is the name authenticator apps show next to the account
+const TOTPIssuer = "OlympusBlog"
+
+const (
+	recoveryCodeCount      = 10
+	challengeTTL           = 5 * time.Minute
+	challengeMaxAttempts   = 5
+	usedCodeTTL            = 3 * utils.TOTPPeriod * time.Second
+	errInvalidSecondFactor = "invalid two-factor code"
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorService interface {
+	Enroll(user *models.User) (string, string, error)
+	Confirm(ctx context.Context, user *models.User, code string) ([]string, error)
+	Disable(ctx context.Context, user *models.User, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error)
+	Verify(ctx context.Context, user *models.User, code string) error
+	CreateChallenge(ctx context.Context, userId uint) (string, error)
+	GetChallenge(ctx context.Context, challenge string) (*models.User, error)
+	DeleteChallenge(ctx context.Context, challenge string)
+}
+
+type twoFactorService struct {
+	db    *gorm.DB
+	redis *redis.Client
+}
+
+func NewTwoFactorService(conn database.Connection, rc database.RedisConnection) TwoFactorService {
+	return &twoFactorService{
+		db:    conn.Get(),
+		redis: rc.Get(),
+	}
+}
+
+// Enroll creates a new secret for the user and returns it together with the otpauth URI.
+// Two-factor authentication stays disabled until the first code has been confirmed.
+func (ts *twoFactorService) Enroll(user *models.User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", apperrors.NewBadRequest("two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := ts.db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return "", "", err
+	}
+
+	return secret, utils.TOTPURI(TOTPIssuer, user.Email, secret), nil
+}
+
+// Confirm enables two-factor authentication once the user proves the authenticator
+// is set up and returns the recovery codes, which can only be shown once
+func (ts *twoFactorService) Confirm(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, apperrors.NewBadRequest("two-factor authentication is already enabled")
+	}
+
+	if user.TOTPSecret == "" {
+		return nil, apperrors.NewBadRequest("two-factor authentication has not been enrolled")
+	}
+
+	if err := ts.verifyTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = createRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	return codes, err
+}
+
+// Disable turns off two-factor authentication and removes the secret and the recovery codes
+func (ts *twoFactorService) Disable(ctx context.Context, user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return apperrors.NewBadRequest("two-factor authentication is not enabled")
+	}
+
+	if err := ts.Verify(ctx, user, code); err != nil {
+		return err
+	}
+
+	return ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":  "",
+			"totp_enabled": false,
+		}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user with new ones
+func (ts *twoFactorService) RegenerateRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, apperrors.NewBadRequest("two-factor authentication is not enabled")
+	}
+
+	if err := ts.Verify(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = createRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	return codes, err
+}
+
+// Verify accepts either a code from the authenticator or an unused recovery code
+func (ts *twoFactorService) Verify(ctx context.Context, user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) == utils.TOTPDigits {
+		return ts.verifyTOTP(ctx, user, code)
+	}
+
+	result := ts.db.
+		Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewAuthorization(errInvalidSecondFactor)
+	}
+	return nil
+}
+
+// CreateChallenge returns a short lived token that allows to finish the login with the second factor
+func (ts *twoFactorService) CreateChallenge(ctx context.Context, userId uint) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ts.redis.Set(ctx, challengeKey(token), userId, challengeTTL).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetChallenge returns the user the challenge was created for.
+// The challenge is discarded after too many attempts.
+func (ts *twoFactorService) GetChallenge(ctx context.Context, challenge string) (*models.User, error) {
+	key := challengeKey(challenge)
+
+	val, err := ts.redis.Get(ctx, key).Result()
+	if err != nil {
+		return nil, apperrors.NewAuthorization("invalid or expired challenge")
+	}
+
+	attempts, err := ts.redis.Incr(ctx, key+":attempts").Result()
+	if err != nil {
+		return nil, err
+	}
+	ts.redis.Expire(ctx, key+":attempts", challengeTTL)
+
+	if attempts > challengeMaxAttempts {
+		ts.DeleteChallenge(ctx, challenge)
+		return nil, apperrors.NewAuthorization("invalid or expired challenge")
+	}
+
+	id, _ := strconv.Atoi(val)
+
+	var user models.User
+	if err := ts.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// DeleteChallenge discards the challenge once the login has been completed
+func (ts *twoFactorService) DeleteChallenge(ctx context.Context, challenge string) {
+	key := challengeKey(challenge)
+	ts.redis.Del(ctx, key, key+":attempts")
+}
+
+// verifyTOTP checks the code and marks its time step as used, so that
+// an intercepted code cannot be replayed while it is still valid
+func (ts *twoFactorService) verifyTOTP(ctx context.Context, user *models.User, code string) error {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return apperrors.NewAuthorization(errInvalidSecondFactor)
+	}
+
+	fresh, err := ts.redis.SetNX(ctx, fmt.Sprintf("2fa-used:%d:%d", user.ID, step), 1, usedCodeTTL).Result()
+	if err != nil {
+		return err
+	}
+
+	if !fresh {
+		return apperrors.NewAuthorization(errInvalidSecondFactor)
+	}
+	return nil
+}
+
+func createRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		records[i] = models.RecoveryCode{
+			UserID:   userId,
+			CodeHash: utils.HashToken(code),
+		}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode allows the code to be entered without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+func challengeKey(token string) string {
+	return fmt.Sprintf("2fa-challenge:%s", token)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"github.com/sentrionic/OlympusGin/config"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// UploadCleaner periodically removes the uploads that no article has embedded
+// within the retention period, uploads.retention, which defaults to a week
+type UploadCleaner interface {
+	Start(ctx context.Context)
+}
+
+type uploadCleaner struct {
+	us        UploadService
+	interval  time.Duration
+	retention time.Duration
+}
+
+func NewUploadCleaner(us UploadService, c *config.Config) UploadCleaner {
+	cfg := c.Get()
+
+	interval := cfg.GetDuration("uploads.interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	retention := cfg.GetDuration("uploads.retention")
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+
+	return &uploadCleaner{
+		us:        us,
+		interval:  interval,
+		retention: retention,
+	}
+}
+
+// Start runs the cleaner in the background until the context is cancelled
+func (uc *uploadCleaner) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(uc.interval)
+		defer ticker.Stop()
+
+		for {
+			uc.run()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// run keeps removing batches until no unreferenced upload is left
+func (uc *uploadCleaner) run() {
+	total := 0
+	for {
+		count, err := uc.us.DeleteUnreferenced(time.Now().Add(-uc.retention))
+		total += count
+
+		if err != nil {
+			log.Errorf("error removing unreferenced uploads: %v", err)
+			break
+		}
+
+		if count < uploadCleanupBatch {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Infof("removed %d unreferenced uploads", total)
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"mime/multipart"
+	"regexp"
+	"time"
+)
+
+// Default upload quotas of a user, which can be changed with uploads.maxCount and uploads.maxBytes
+const (
+	defaultUploadMaxCount = 500
+	defaultUploadMaxBytes = 100 << 20
+	// uploadCleanupBatch is how many unreferenced uploads are removed at once
+	uploadCleanupBatch = 100
+)
+
+type UploadService interface {
+	Create(userId uint, header *multipart.FileHeader) (*models.Upload, error)
+	DeleteUnreferenced(before time.Time) (int, error)
+}
+
+type uploadService struct {
+	db       *gorm.DB
+	fs       FileService
+	maxCount int64
+	maxBytes int64
+}
+
+func NewUploadService(conn database.Connection, fs FileService, c *config.Config) UploadService {
+	cfg := c.Get()
+
+	maxCount := cfg.GetInt64("uploads.maxCount")
+	if maxCount <= 0 {
+		maxCount = defaultUploadMaxCount
+	}
+
+	maxBytes := cfg.GetInt64("uploads.maxBytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultUploadMaxBytes
+	}
+
+	return &uploadService{
+		db:       conn.Get(),
+		fs:       fs,
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+	}
+}
+
+// Create stores the image in all its variants if the user has not used up
+// their quota. The quota counts the bytes of the stored variants; the size of
+// the uploaded file is only used as an estimate to reject uploads early.
+func (us *uploadService) Create(userId uint, header *multipart.FileHeader) (*models.Upload, error) {
+	if err := us.checkQuota(us.db, userId, header.Size); err != nil {
+		return nil, err
+	}
+
+	image, err := us.fs.UploadImage(header, fmt.Sprintf("gin/users/%d/uploads", userId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	upload := models.Upload{
+		UserID:    userId,
+		KeyPrefix: image.KeyPrefix,
+		URL:       image.URL,
+		Width:     image.Width,
+		Height:    image.Height,
+		Size:      image.Size,
+		Variants:  image.Variants,
+	}
+
+	// The user is locked while the quota is checked again and the upload is added,
+	// so that concurrent uploads cannot exceed the quota together
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userId).Error; err != nil {
+			return err
+		}
+
+		if err := us.checkQuota(tx, userId, upload.Size); err != nil {
+			return err
+		}
+
+		return tx.Create(&upload).Error
+	})
+
+	if err != nil {
+		us.deleteImages(&upload)
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// checkQuota returns a Forbidden error if size more bytes or one more upload
+// would exceed the quota of the user
+func (us *uploadService) checkQuota(db *gorm.DB, userId uint, size int64) error {
+	var usage struct {
+		Count int64
+		Bytes int64
+	}
+
+	err := db.
+		Model(&models.Upload{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Where("user_id = ?", userId).
+		Scan(&usage).
+		Error
+
+	if err != nil {
+		return err
+	}
+
+	if usage.Count >= us.maxCount {
+		return apperrors.NewForbidden(fmt.Sprintf("upload quota of %d images exceeded", us.maxCount))
+	}
+
+	if usage.Bytes+size > us.maxBytes {
+		return apperrors.NewForbidden(fmt.Sprintf("upload quota of %d bytes exceeded", us.maxBytes))
+	}
+
+	return nil
+}
+
+// DeleteUnreferenced removes the uploads created before the given time that are
+// not embedded in any article and returns how many were removed. Older revisions
+// count as well, so that restoring a revision never brings back a broken image.
+func (us *uploadService) DeleteUnreferenced(before time.Time) (int, error) {
+	var ids []uint
+	err := us.db.
+		Model(&models.Upload{}).
+		Where("created_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM article_uploads WHERE article_uploads.upload_id = uploads.id)").
+		Order("id").
+		Limit(uploadCleanupBatch).
+		Pluck("id", &ids).
+		Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, id := range ids {
+		deleted, err := us.deleteUnreferenced(id)
+
+		if err != nil {
+			// The upload is kept, so that the next run tries again
+			log.Errorf("error deleting upload %d: %v", id, err)
+			continue
+		}
+
+		if deleted {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// deleteUnreferenced removes the upload unless an article started to embed it.
+// The upload is locked, so that linkUploads waits until it is gone.
+func (us *uploadService) deleteUnreferenced(id uint) (bool, error) {
+	deleted := false
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var upload models.Upload
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&upload, id).Error; err != nil {
+			return err
+		}
+
+		var references int64
+		if err := tx.Model(&models.ArticleUpload{}).Where("upload_id = ?", id).Count(&references).Error; err != nil {
+			return err
+		}
+
+		if references > 0 {
+			return nil
+		}
+
+		if err := us.deleteImages(&upload); err != nil {
+			return err
+		}
+
+		deleted = true
+		return tx.Delete(&upload).Error
+	})
+
+	return deleted && err == nil, err
+}
+
+// uploadKeyPrefix matches the URL of an upload variant and captures the key prefix of the upload
+var uploadKeyPrefix = regexp.MustCompile(`(` + OwnedKeyPrefix + `\d+/uploads/[A-Za-z0-9-]+-)\d+w\.`)
+
+// linkUploads records the uploads the body embeds for the article. Uploads that
+// are being removed at the same time are waited for and then skipped.
+func linkUploads(tx *gorm.DB, articleId uint, body string) error {
+	matches := uploadKeyPrefix.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	prefixes := make([]string, len(matches))
+	for i, m := range matches {
+		prefixes[i] = m[1]
+	}
+
+	var ids []uint
+	err := tx.
+		Model(&models.Upload{}).
+		Clauses(clause.Locking{Strength: "KEY SHARE"}).
+		Where("key_prefix IN ?", prefixes).
+		Pluck("id", &ids).
+		Error
+
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	links := make([]models.ArticleUpload, len(ids))
+	for i, id := range ids {
+		links[i] = models.ArticleUpload{ArticleID: articleId, UploadID: id}
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+}
+
+func (us *uploadService) deleteImages(upload *models.Upload) error {
+	for _, v := range upload.Variants {
+		if err := us.fs.DeleteImage(v.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"fmt"
 	"github.com/sentrionic/OlympusGin/database"
 	"github.com/sentrionic/OlympusGin/models"
 	"github.com/sentrionic/OlympusGin/utils"
@@ -15,14 +16,22 @@ type UserService interface {
 	ChangePassword(id uint, password string) error
 	GetByEmail(email string) (*models.User, error)
 	GetByUsername(username string) (*models.User, error)
+	List(search string, limit int, page int) (*[]models.User, error)
+	SetRole(id uint, role string) error
+	Delete(id uint) ([]string, error)
+	Export(id uint) (*models.UserExport, error)
 }
 
 type userService struct {
 	db *gorm.DB
+	ws WebhookService
 }
 
-func NewUserService(conn database.Connection) UserService {
-	return &userService{db: conn.Get()}
+func NewUserService(conn database.Connection, ws WebhookService) UserService {
+	return &userService{
+		db: conn.Get(),
+		ws: ws,
+	}
 }
 
 func (us *userService) GetById(id uint) (*models.User, error) {
@@ -65,3 +74,200 @@ func (us *userService) ChangePassword(id uint, password string) error {
 	}
 	return nil
 }
+
+func (us *userService) List(search string, limit int, page int) (*[]models.User, error) {
+	var u []models.User
+	query := us.db.Order("id ASC").Limit(limit)
+
+	if search != "" {
+		search = "%" + strings.ToLower(search) + "%"
+		query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ?", search, search)
+	}
+
+	if page > 1 {
+		query.Offset((page - 1) * limit)
+	}
+
+	result := query.Find(&u)
+	return &u, result.Error
+}
+
+func (us *userService) SetRole(id uint, role string) error {
+	result := us.db.Table("users").Where("id = ?", id).Updates(map[string]interface{}{
+		"role":       role,
+		"updated_at": time.Now(),
+	})
+	return result.Error
+}
+
+// Delete removes the articles, uploads, comments, favorites, bookmarks, follows and tokens of the user
+// and returns the storage keys of the removed images, which have to be deleted from the storage.
+// Comments that other users replied to are kept as tombstones, in which case the account
+// is anonymized instead of removed, since the tombstones still reference it.
+func (us *userService) Delete(id uint) ([]string, error) {
+	var images []string
+	var articles []models.Article
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+
+		if user.ImageKey != "" {
+			images = append(images, user.ImageKey)
+		}
+
+		if err := tx.Where("author_id = ?", id).Find(&articles).Error; err != nil {
+			return err
+		}
+
+		for _, a := range articles {
+			if a.ImageKey != "" {
+				images = append(images, a.ImageKey)
+			}
+			for _, v := range a.ImageVariants {
+				images = append(images, v.Key)
+			}
+			if err := deleteArticle(tx, a.ID); err != nil {
+				return err
+			}
+		}
+
+		var uploads []models.Upload
+		if err := tx.Where("user_id = ?", id).Find(&uploads).Error; err != nil {
+			return err
+		}
+
+		for _, u := range uploads {
+			for _, v := range u.Variants {
+				images = append(images, v.Key)
+			}
+		}
+
+		// Replies first, so that threads of the user are removed instead of tombstoned
+		var comments []models.Comment
+		if err := tx.Where("author_id = ?", id).Order("depth DESC").Find(&comments).Error; err != nil {
+			return err
+		}
+
+		for _, c := range comments {
+			var exists int64
+			if err := tx.Model(&models.Comment{}).Where("id = ?", c.ID).Count(&exists).Error; err != nil {
+				return err
+			}
+
+			// Already removed together with a reply
+			if exists == 0 {
+				continue
+			}
+
+			if err := deleteComment(tx, c); err != nil {
+				return err
+			}
+		}
+
+		err := tx.Exec("DELETE FROM comment_edits WHERE comment_id IN (SELECT id FROM comments WHERE author_id = ?)", id).
+			Exec("DELETE FROM article_favorites WHERE user_id = ?", id).
+			Exec("DELETE FROM article_bookmarks WHERE user_id = ?", id).
+			Exec("DELETE FROM followers WHERE user_id = ? OR follower_id = ?", id, id).
+			Exec("DELETE FROM followee WHERE user_id = ? OR followee_id = ?", id, id).
+			Exec("DELETE FROM access_tokens WHERE user_id = ?", id).
+			Exec("DELETE FROM recovery_codes WHERE user_id = ?", id).
+			Exec("DELETE FROM article_uploads WHERE upload_id IN (SELECT id FROM uploads WHERE user_id = ?)", id).
+			Exec("DELETE FROM uploads WHERE user_id = ?", id).
+			Exec("DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)", id).
+			Exec("DELETE FROM webhooks WHERE user_id = ?", id).
+			Exec("DELETE FROM notification_actors WHERE actor_id = ? OR notification_id IN (SELECT id FROM notifications WHERE user_id = ? OR actor_id = ?)", id, id, id).
+			Exec("DELETE FROM notifications WHERE user_id = ? OR actor_id = ?", id, id).
+			Error
+
+		if err != nil {
+			return err
+		}
+
+		var tombstones int64
+		if err := tx.Model(&models.Comment{}).Where("author_id = ?", id).Count(&tombstones).Error; err != nil {
+			return err
+		}
+
+		if tombstones == 0 {
+			return tx.Delete(&models.User{}, id).Error
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"username":       fmt.Sprintf("deleted-user-%d", id),
+			"email":          fmt.Sprintf("deleted-user-%d@deleted.invalid", id),
+			"email_verified": false,
+			"bio":            "",
+			"image":          "",
+			"image_key":      "",
+			"password":       "",
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"role":           models.RoleUser,
+			"updated_at":     time.Now(),
+		}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range articles {
+		emitWebhook(us.ws, models.WebhookArticleDeleted, id, articles[i].Status == models.StatusPublished, articleWebhookData(&articles[i]))
+	}
+
+	return images, nil
+}
+
+// Export loads everything stored about the user
+func (us *userService) Export(id uint) (*models.UserExport, error) {
+	var e models.UserExport
+
+	if err := us.db.First(&e.User, id).Error; err != nil {
+		return nil, err
+	}
+
+	err := us.db.
+		Preload("Tags").
+		Where("author_id = ?", id).
+		Order("id ASC").
+		Find(&e.Articles).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = us.db.
+		Preload("Article").
+		Where("author_id = ? AND deleted = false", id).
+		Order("id ASC").
+		Find(&e.Comments).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = us.db.
+		Joins("JOIN article_favorites ON article_favorites.article_id = articles.id").
+		Where("article_favorites.user_id = ?", id).
+		Order("articles.id ASC").
+		Find(&e.Favorites).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = us.db.
+		Joins("JOIN article_bookmarks ON article_bookmarks.article_id = articles.id").
+		Where("article_bookmarks.user_id = ?", id).
+		Order("articles.id ASC").
+		Find(&e.Bookmarks).
+		Error
+
+	return &e, err
+}
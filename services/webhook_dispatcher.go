@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"github.com/sentrionic/OlympusGin/config"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// WebhookDispatcher periodically sends the queued webhook deliveries that are due
+type WebhookDispatcher interface {
+	Start(ctx context.Context)
+}
+
+type webhookDispatcher struct {
+	ws       WebhookService
+	interval time.Duration
+}
+
+func NewWebhookDispatcher(ws WebhookService, c *config.Config) WebhookDispatcher {
+	interval := c.Get().GetDuration("webhooks.interval")
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	return &webhookDispatcher{
+		ws:       ws,
+		interval: interval,
+	}
+}
+
+// Start runs the dispatcher in the background until the context is cancelled
+func (wd *webhookDispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(wd.interval)
+		defer ticker.Stop()
+
+		for {
+			wd.run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// run keeps sending batches until nothing is due anymore
+func (wd *webhookDispatcher) run(ctx context.Context) {
+	for {
+		count, err := wd.ws.DeliverDue(ctx)
+
+		if err != nil {
+			log.Errorf("error delivering webhooks: %v", err)
+			return
+		}
+
+		if count < webhookBatchSize {
+			return
+		}
+	}
+}
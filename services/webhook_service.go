@@ -0,0 +1,359 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sentrionic/OlympusGin/config"
+	"github.com/sentrionic/OlympusGin/database"
+	"github.com/sentrionic/OlympusGin/models"
+	"github.com/sentrionic/OlympusGin/utils"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 20
+	// webhookLease is how long a claimed delivery is hidden from other workers
+	webhookLease       = 2 * time.Minute
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+var errPrivateAddress = errors.New("webhooks cannot be delivered to private addresses")
+
+// privateNetworks are the ranges webhooks are not allowed to reach,
+// so that they cannot be used to probe the internal network
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+type WebhookService interface {
+	Create(userId uint, url string, events []string, global bool) (*models.Webhook, error)
+	List(userId uint) (*[]models.Webhook, error)
+	Get(userId uint, id uint) (*models.Webhook, error)
+	Delete(userId uint, id uint) error
+	Deliveries(webhookId uint, limit int, page int) (*[]models.WebhookDelivery, error)
+	Emit(event string, ownerId uint, public bool, data interface{}) error
+	DeliverDue(ctx context.Context) (int, error)
+}
+
+type webhookService struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+// NewWebhookService creates the service. Deliveries to private networks are
+// refused unless webhooks.allowPrivateNetworks is set, e.g. for local development.
+func NewWebhookService(conn database.Connection, c *config.Config) WebhookService {
+	allowPrivate := c.Get().GetBool("webhooks.allowPrivateNetworks")
+
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &webhookService{
+		db: conn.Get(),
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// Redirects are not followed, the endpoint has to answer itself
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Create registers the endpoint with a new signing secret
+func (ws *webhookService) Create(userId uint, url string, events []string, global bool) (*models.Webhook, error) {
+	random, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	w := models.Webhook{
+		UserID: userId,
+		URL:    url,
+		Secret: webhookSecretPrefix + random,
+		Events: strings.Join(events, ","),
+		Global: global,
+		Active: true,
+	}
+
+	result := ws.db.Create(&w)
+	return &w, result.Error
+}
+
+func (ws *webhookService) List(userId uint) (*[]models.Webhook, error) {
+	var w []models.Webhook
+	result := ws.db.
+		Where("user_id = ?", userId).
+		Order("id DESC").
+		Find(&w)
+
+	return &w, result.Error
+}
+
+func (ws *webhookService) Get(userId uint, id uint) (*models.Webhook, error) {
+	var w models.Webhook
+	result := ws.db.
+		Where("id = ? AND user_id = ?", id, userId).
+		First(&w)
+
+	return &w, result.Error
+}
+
+// Delete removes the webhook together with its delivery log
+func (ws *webhookService) Delete(userId uint, id uint) error {
+	return ws.db.Transaction(func(tx *gorm.DB) error {
+		var w models.Webhook
+		if err := tx.Where("id = ? AND user_id = ?", id, userId).First(&w).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("webhook_id = ?", w.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&w).Error
+	})
+}
+
+// Deliveries returns the delivery log of the webhook, newest first
+func (ws *webhookService) Deliveries(webhookId uint, limit int, page int) (*[]models.WebhookDelivery, error) {
+	var d []models.WebhookDelivery
+	query := ws.db.
+		Where("webhook_id = ?", webhookId).
+		Order("id DESC").
+		Limit(limit)
+
+	if page > 1 {
+		query.Offset((page - 1) * limit)
+	}
+
+	result := query.Find(&d)
+	return &d, result.Error
+}
+
+// Emit queues the event for every active webhook of the owner of the content
+// that subscribed to it. Events about public content also go to the global
+// webhooks, as long as their creator is still allowed to manage webhooks.
+func (ws *webhookService) Emit(event string, ownerId uint, public bool, data interface{}) error {
+	query := ws.db.
+		Where("webhooks.active = true").
+		Where("(',' || webhooks.events || ',') LIKE ?", "%,"+event+",%")
+
+	if public {
+		query = query.
+			Select("webhooks.*").
+			Joins("JOIN users ON users.id = webhooks.user_id").
+			Where("(webhooks.user_id = ? OR (webhooks.global = true AND users.role IN ?))", ownerId, models.RolesWithPermission(models.PermManageWebhooks))
+	} else {
+		query = query.Where("webhooks.user_id = ?", ownerId)
+	}
+
+	var hooks []models.Webhook
+	err := query.Find(&hooks).Error
+
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"createdAt": now,
+		"data":      data,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: &now,
+		}
+	}
+
+	return ws.db.Create(&deliveries).Error
+}
+
+// DeliverDue sends the pending deliveries whose next attempt is due and returns
+// how many were sent. The deliveries are leased with SKIP LOCKED, so that
+// several replicas can run the dispatcher without sending a delivery twice.
+func (ws *webhookService) DeliverDue(ctx context.Context) (int, error) {
+	var ids []uint
+	err := ws.db.Raw(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`, time.Now().Add(webhookLease), models.DeliveryPending, webhookBatchSize).Scan(&ids).Error
+
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := ws.db.Preload("Webhook").Find(&deliveries, ids).Error; err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(d *models.WebhookDelivery) {
+			defer wg.Done()
+			ws.deliver(ctx, d)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// deliver sends the delivery once and schedules the next attempt with an
+// exponential backoff if it failed
+func (ws *webhookService) deliver(ctx context.Context, d *models.WebhookDelivery) {
+	now := time.Now()
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = 0
+	d.Error = ""
+
+	if !d.Webhook.Active {
+		d.Error = "webhook is disabled"
+	} else {
+		status, err := ws.send(ctx, d)
+		d.ResponseStatus = status
+
+		if err != nil {
+			d.Error = err.Error()
+		} else if status < 200 || status >= 300 {
+			d.Error = fmt.Sprintf("endpoint responded with status %d", status)
+		}
+	}
+
+	switch {
+	case d.Error == "":
+		d.Status = models.DeliverySucceeded
+		d.NextAttemptAt = nil
+	case d.Attempts >= webhookMaxAttempts || !d.Webhook.Active:
+		d.Status = models.DeliveryFailed
+		d.NextAttemptAt = nil
+	default:
+		backoff := webhookBaseBackoff << (d.Attempts - 1)
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+		next := now.Add(backoff)
+		d.NextAttemptAt = &next
+	}
+
+	err := ws.db.Model(d).Updates(map[string]interface{}{
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"next_attempt_at": d.NextAttemptAt,
+		"last_attempt_at": d.LastAttemptAt,
+		"response_status": d.ResponseStatus,
+		"error":           d.Error,
+	}).Error
+
+	if err != nil {
+		log.Errorf("error saving webhook delivery %d: %v", d.ID, err)
+	}
+}
+
+func (ws *webhookService) send(ctx context.Context, d *models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OlympusBlog-Webhooks")
+	req.Header.Set("X-Olympus-Event", d.Event)
+	req.Header.Set("X-Olympus-Delivery", fmt.Sprint(d.ID))
+	req.Header.Set("X-Olympus-Signature", utils.SignPayload(d.Webhook.Secret, time.Now().Unix(), body))
+
+	res, err := ws.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Drain a bit of the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+
+	return res.StatusCode, nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// emitWebhook queues a webhook event on behalf of another service.
+// Like notify, a failure is only logged and never fails the action itself.
+func emitWebhook(ws WebhookService, event string, ownerId uint, public bool, data interface{}) {
+	if err := ws.Emit(event, ownerId, public, data); err != nil {
+		log.Errorf("error queueing %s webhook: %v", event, err)
+	}
+}
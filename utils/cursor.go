@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Cursor marks the position of the last item of a page.
+// Key is the text form of the value the list is sorted by and
+// Id breaks ties between items sharing the same key.
+type Cursor struct {
+	Order string `json:"o"`
+	Key   string `json:"k"`
+	Id    uint   `json:"i"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor serializes the cursor into an opaque string signed with the secret
+func EncodeCursor(secret string, c Cursor) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signCursor(secret, encoded), nil
+}
+
+// DecodeCursor verifies the signature of the cursor and returns its content
+func DecodeCursor(secret string, cursor string) (*Cursor, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(signCursor(secret, parts[0]))) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+func signCursor(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
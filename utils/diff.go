@@ -0,0 +1,151 @@
+package utils
+
+import "strings"
+
+// Diff operations
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffEdits bounds the search for the middle snake. Ranges that differ
+// more are reported as deleted and inserted as a whole, so that comparing
+// two large, unrelated bodies stays cheap.
+const maxDiffEdits = 1000
+
+// DiffLines returns a line based diff that turns a into b,
+// computed with Myers' algorithm in linear space
+func DiffLines(a, b string) []DiffLine {
+	return diff(splitLines(a), splitLines(b))
+}
+
+// DiffSlices returns the diff between two lists of strings, e.g. tags
+func DiffSlices(a, b []string) []DiffLine {
+	return diff(a, b)
+}
+
+func diff(a, b []string) []DiffLine {
+	return diffRange(a, b, make([]DiffLine, 0, len(a)+len(b)))
+}
+
+// diffRange appends the diff of a and b to lines. It strips the common prefix
+// and suffix and splits the rest at the middle snake of the shortest edit script.
+func diffRange(a, b []string, lines []DiffLine) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, DiffLine{DiffEqual, a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if len(a) > 0 && len(b) > 0 {
+		if x, y, u, v, ok := middleSnake(a, b); ok {
+			lines = diffRange(a[:x], b[:y], lines)
+			for _, line := range a[x:u] {
+				lines = append(lines, DiffLine{DiffEqual, line})
+			}
+			lines = diffRange(a[u:], b[v:], lines)
+			a, b = nil, nil
+		}
+	}
+
+	for _, line := range a {
+		lines = append(lines, DiffLine{DiffDelete, line})
+	}
+	for _, line := range b {
+		lines = append(lines, DiffLine{DiffInsert, line})
+	}
+	for _, line := range common {
+		lines = append(lines, DiffLine{DiffEqual, line})
+	}
+
+	return lines
+}
+
+// middleSnake searches forward from the start and backward from the end at the
+// same time, until both paths overlap. The overlapping snake runs from (x, y) to
+// (u, v) and splits the edit script in two halves. ok is false if more than
+// maxDiffEdits edits would be needed on either side.
+func middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+
+	max := (n + m + 1) / 2
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+
+	// forward[k] is the furthest x on diagonal k = x - y, backward[c] the
+	// furthest distance from the end on the reversed diagonal c = delta - k
+	offset := max + 1
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+backward[offset+c] >= n {
+				return startX, startY, x, y, true
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var rx int
+			if c == -d || (c != d && backward[offset+c-1] < backward[offset+c+1]) {
+				rx = backward[offset+c+1]
+			} else {
+				rx = backward[offset+c-1] + 1
+			}
+			ry := rx - c
+			startX, startY := rx, ry
+
+			for rx < n && ry < m && a[n-1-rx] == b[m-1-ry] {
+				rx++
+				ry++
+			}
+			backward[offset+c] = rx
+
+			if k := delta - c; !odd && k >= -d && k <= d && rx+forward[offset+k] >= n {
+				return n - rx, m - ry, n - startX, m - startY, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, false
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(s, "\n")
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"errors"
+	"github.com/sentrionic/OlympusGin/models/apperrors"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"net/http"
@@ -28,3 +30,13 @@ func ErrorFromDatabase(err error) (int, *ApiError) {
 		return CreateApiError(http.StatusInternalServerError, err)
 	}
 }
+
+// ErrorFromService returns the status of application errors
+// and falls back to ErrorFromDatabase for everything else
+func ErrorFromService(err error) (int, *ApiError) {
+	var e *apperrors.Error
+	if errors.As(err, &e) {
+		return CreateApiError(e.Status(), e)
+	}
+	return ErrorFromDatabase(err)
+}
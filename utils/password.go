@@ -1,13 +1,148 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
 
+// Argon2Params are the cost parameters of Argon2id. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the recommendation of RFC 9106 for memory constrained environments
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var argon2Params = DefaultArgon2Params
+
+var errInvalidHash = errors.New("the encoded hash is not in the correct format")
+
+// Validate returns an error for parameters Argon2id cannot work with
+func (p Argon2Params) Validate() error {
+	switch {
+	case p.Iterations < 1:
+		return errors.New("argon2 iterations must be at least 1")
+	case p.Parallelism < 1:
+		return errors.New("argon2 parallelism must be at least 1")
+	case p.Memory < 8*uint32(p.Parallelism):
+		return fmt.Errorf("argon2 memory must be at least %d KiB for a parallelism of %d", 8*uint32(p.Parallelism), p.Parallelism)
+	case p.SaltLength < 1 || p.KeyLength < 1:
+		return errors.New("argon2 salt and key length must be at least 1")
+	}
+	return nil
+}
+
+// SetArgon2Params changes the parameters new passwords are hashed with.
+// Existing hashes keep working and are rehashed on the next login.
+func SetArgon2Params(p Argon2Params) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	argon2Params = p
+	return nil
+}
+
+// HashPassword returns the Argon2id hash of the password encoded as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	p := argon2Params
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// CheckPassword compares the password with an Argon2id or a legacy bcrypt hash
 func CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		return err == nil
+	}
+
+	p, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil || p.Validate() != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// NeedsRehash reports whether the hash uses another algorithm
+// or other parameters than the ones new passwords are hashed with
+func NeedsRehash(hash string) bool {
+	p, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+
+	current := argon2Params
+	return p.Memory != current.Memory ||
+		p.Iterations != current.Iterations ||
+		p.Parallelism != current.Parallelism ||
+		p.SaltLength != current.SaltLength ||
+		p.KeyLength != current.KeyLength
+}
+
+func decodeArgon2Hash(hash string) (*Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, errInvalidHash
+	}
+
+	p := &Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
 }
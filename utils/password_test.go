@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep the tests fast, they are not meant for production
+var testArgon2Params = Argon2Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func useArgon2Params(t *testing.T, p Argon2Params) {
+	previous := argon2Params
+	if err := SetArgon2Params(p); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { argon2Params = previous })
+}
+
+func TestHashPassword(t *testing.T) {
+	useArgon2Params(t, testArgon2Params)
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("got hash %s, want the argon2id parameters in it", hash)
+	}
+
+	if !CheckPassword("correct horse", hash) {
+		t.Error("the password does not match its hash")
+	}
+	if CheckPassword("correct horse!", hash) {
+		t.Error("another password matches the hash")
+	}
+
+	other, _ := HashPassword("correct horse")
+	if other == hash {
+		t.Error("two hashes of the same password use the same salt")
+	}
+}
+
+func TestCheckPasswordBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("legacy password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !CheckPassword("legacy password", string(hash)) {
+		t.Error("the password does not match its bcrypt hash")
+	}
+	if CheckPassword("another password", string(hash)) {
+		t.Error("another password matches the bcrypt hash")
+	}
+}
+
+func TestCheckPasswordInvalidHash(t *testing.T) {
+	hashes := []string{
+		"",
+		"plain text",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64$a2V5",
+	}
+
+	for _, hash := range hashes {
+		if CheckPassword("password", hash) {
+			t.Errorf("hash %q was accepted", hash)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	useArgon2Params(t, testArgon2Params)
+
+	current, _ := HashPassword("password")
+	if NeedsRehash(current) {
+		t.Error("a hash with the current parameters needs a rehash")
+	}
+
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if !NeedsRehash(string(legacy)) {
+		t.Error("a bcrypt hash does not need a rehash")
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	useArgon2Params(t, stronger)
+
+	if !NeedsRehash(current) {
+		t.Error("a hash with outdated parameters does not need a rehash")
+	}
+}
+
+func TestSetArgon2ParamsRejectsInvalidParams(t *testing.T) {
+	useArgon2Params(t, testArgon2Params)
+
+	invalid := map[string]Argon2Params{
+		"no iterations":     {Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"no parallelism":    {Memory: 64, Iterations: 1, Parallelism: 0, SaltLength: 16, KeyLength: 32},
+		"too little memory": {Memory: 15, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		"no key":            {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 0},
+	}
+
+	for name, p := range invalid {
+		if err := SetArgon2Params(p); err == nil {
+			t.Errorf("%s: the params were accepted", name)
+		}
+	}
+
+	if argon2Params != testArgon2Params {
+		t.Error("invalid params replaced the current ones")
+	}
+
+	if err := SetArgon2Params(DefaultArgon2Params); err != nil {
+		t.Errorf("the default params were rejected: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// SignPayload returns the signature header of a webhook payload in the form
+// t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">.
+// Signing the timestamp lets receivers reject replayed deliveries.
+func SignPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
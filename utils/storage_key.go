@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// KeyFromURL returns the storage key starting with prefix that the URL of a file
+// points to, or an empty string if it points elsewhere. Storage URLs escape the
+// key, e.g. S3 turns spaces into %20, so the key is unescaped again.
+func KeyFromURL(rawURL string, prefix string) string {
+	index := strings.Index(rawURL, "/"+prefix)
+	if index < 0 {
+		return ""
+	}
+
+	key := rawURL[index+1:]
+	if end := strings.IndexAny(key, "?#"); end >= 0 {
+		key = key[:end]
+	}
+
+	unescaped, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+	return unescaped
+}
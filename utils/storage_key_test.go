@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestKeyFromURL(t *testing.T) {
+	const prefix = "files/gin/users/"
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{
+			"plain key",
+			"https://bucket.s3.amazonaws.com/files/gin/users/1/abcde-cat.jpeg",
+			"files/gin/users/1/abcde-cat.jpeg",
+		},
+		{
+			"escaped legacy key",
+			"https://bucket.s3.eu-central-1.amazonaws.com/files/gin/users/1/abcde-my%20photo%20gr%C3%BC%C3%9Fe.jpeg",
+			"files/gin/users/1/abcde-my photo grüße.jpeg",
+		},
+		{
+			"plus sign stays",
+			"https://bucket.s3.amazonaws.com/files/gin/users/1/abcde-a+b.jpeg",
+			"files/gin/users/1/abcde-a+b.jpeg",
+		},
+		{
+			"signed local URL",
+			"http://localhost:8080/files/gin/users/2/articles/abcde-320w.webp?expires=1&signature=ab%20cd#top",
+			"files/gin/users/2/articles/abcde-320w.webp",
+		},
+		{
+			"invalid escape",
+			"https://bucket.s3.amazonaws.com/files/gin/users/1/100%-cat.jpeg",
+			"files/gin/users/1/100%-cat.jpeg",
+		},
+		{"placeholder", "https://picsum.photos/seed/1/1080", ""},
+		{"gravatar", "https://gravatar.com/avatar/abc?d=identicon", ""},
+	}
+
+	for _, tt := range tests {
+		if got := KeyFromURL(tt.url, prefix); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as recommended by RFC 6238 and supported by all authenticator apps
+const (
+	TOTPPeriod = 30
+	TOTPDigits = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpModulus is 10^TOTPDigits, the codes are the truncated HMAC modulo this value
+var totpModulus = func() uint32 {
+	m := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		m *= 10
+	}
+	return m
+}()
+
+// NewTOTPSecret returns a random base32 encoded 160 bit secret
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPStep returns the time step the given time falls into
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode computes the code of the secret for the given time step (RFC 4226 section 5.3)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%totpModulus), nil
+}
+
+// ValidateTOTP checks the code against the current time step and the ones next to it
+// to allow for clock drift. It returns the matching step so it can be marked as used.
+func ValidateTOTP(secret string, code string, t time.Time) (int64, bool) {
+	current := TOTPStep(t)
+	for _, step := range []int64{current, current - 1, current + 1} {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI returns the otpauth URI authenticator apps read from a QR code
+func TOTPURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(TOTPDigits))
+	values.Set("period", fmt.Sprint(TOTPPeriod))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, values.Encode())
+}
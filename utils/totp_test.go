@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the shared secret of the test vectors in RFC 4226 and RFC 6238
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 4226 appendix D
+func TestTOTPCodeHOTPVectors(t *testing.T) {
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, want := range expected {
+		got, err := TOTPCode(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatalf("counter %d: %v", counter, err)
+		}
+		if got != want {
+			t.Errorf("counter %d: got %s, want %s", counter, got, want)
+		}
+	}
+}
+
+// RFC 6238 appendix B (SHA1), truncated to the six digits used here
+func TestTOTPCodeTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("time %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("time %d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, _ := TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 1)
+	lower, _ := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+
+	if upper != lower {
+		t.Errorf("got %s for the lowercase secret, want %s", lower, upper)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", current, true},
+		{"previous step", current - 1, true},
+		{"next step", current + 1, true},
+		{"two steps ago", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+
+	for _, tt := range tests {
+		code, _ := TOTPCode(rfcSecret, tt.step)
+		step, ok := ValidateTOTP(rfcSecret, code, now)
+
+		if ok != tt.valid {
+			t.Errorf("%s: got valid %v, want %v", tt.name, ok, tt.valid)
+		}
+		if ok && step != tt.step {
+			t.Errorf("%s: got step %d, want %d", tt.name, step, tt.step)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsInvalidCodes(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	for _, code := range []string{"", "000000", "05047", "0504710", "abcdef"} {
+		if _, ok := ValidateTOTP(rfcSecret, code, now); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+}